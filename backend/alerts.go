@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AlertRule raises an alert when its expression (the same language as
+// derived channels) crosses a threshold, or is non-zero when no threshold is
+// set. The condition has to hold for MinDurationMS of session time before the
+// alert is raised. A raised alert clears once the value is back past the
+// threshold by Hysteresis, or as soon as a plain condition is false.
+type AlertRule struct {
+	Name          string   `json:"name"`
+	Expression    string   `json:"expression"`
+	Above         *float64 `json:"above,omitempty"`
+	Below         *float64 `json:"below,omitempty"`
+	Hysteresis    float64  `json:"hysteresis,omitempty"`
+	MinDurationMS int      `json:"minDurationMS,omitempty"`
+	Severity      string   `json:"severity"`          // info, warning or critical
+	Message       string   `json:"message,omitempty"` // {value} is replaced by the value
+	OSCAddress    string   `json:"oscAddress,omitempty"`
+	Enabled       bool     `json:"enabled"`
+}
+
+var alertSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+const defaultAlertSeverity = "warning"
+
+// Number of alert events kept for /api/alerts
+const alertLogSize = 500
+
+// AlertEvent is published as event "alert" when a rule is raised or cleared
+type AlertEvent struct {
+	Rule     string  `json:"rule"`
+	State    string  `json:"state"` // raised or cleared
+	Severity string  `json:"severity"`
+	Value    float64 `json:"value"`
+	Message  string  `json:"message"`
+	Frame    uint32  `json:"frame"`
+	Time     float32 `json:"time"` // session time
+}
+
+var AlertRules = []AlertRule{}
+var alertRulesConfigPath string
+
+// Serializes changes to AlertRules
+var alertRulesMu sync.Mutex
+
+type alertProgram struct {
+	rule    AlertRule
+	node    derivedNode
+	packets map[string]bool
+}
+
+// alertState is the runtime state of one rule
+type alertState struct {
+	active       bool
+	pending      bool    // condition holds, waiting for MinDurationMS
+	pendingSince float32 // session time
+	evaluated    bool
+	value        float64
+	raisedAt     float32
+}
+
+var alerts struct {
+	sync.Mutex
+	programs   []*alertProgram
+	errors     map[string]string // rule name -> compile error
+	states     map[string]*alertState
+	sessionUID uint64
+	lastHeader PacketHeader // of the latest evaluation, for alerts cleared from the API
+	log        []AlertEvent // oldest first
+}
+
+func InitAlertRulesConfig() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	appDir := filepath.Join(configDir, "f1-telem-bridge")
+	os.MkdirAll(appDir, 0755)
+	alertRulesConfigPath = filepath.Join(appDir, "alert_rules.json")
+
+	if _, err := os.Stat(alertRulesConfigPath); os.IsNotExist(err) {
+		SaveAlertRulesConfig()
+	} else {
+		LoadAlertRulesConfig()
+	}
+	compileAlertRules()
+	alerts.Lock()
+	for name, err := range alerts.errors {
+		log.Printf("[config] Alert rule %s is invalid: %s", name, err)
+	}
+	alerts.Unlock()
+}
+
+func SaveAlertRulesConfig() {
+	f, err := os.Create(alertRulesConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not create alert rules config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(AlertRules); err != nil {
+		log.Printf("[error] Could not encode alert rules config: %v", err)
+	}
+}
+
+func LoadAlertRulesConfig() {
+	f, err := os.Open(alertRulesConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not open alert rules config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&AlertRules); err != nil {
+		log.Printf("[error] Could not decode alert rules config: %v", err)
+	}
+}
+
+func findAlertRule(name string) int {
+	for i, rule := range AlertRules {
+		if strings.EqualFold(rule.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateAlertRule(rule AlertRule) error {
+	if !derivedNamePattern.MatchString(rule.Name) {
+		return fmt.Errorf("invalid rule name %q, use letters and digits", rule.Name)
+	}
+	if rule.Above != nil && rule.Below != nil {
+		return fmt.Errorf("set above or below, not both")
+	}
+	if rule.Hysteresis < 0 || rule.MinDurationMS < 0 {
+		return fmt.Errorf("hysteresis and minDurationMS can't be negative")
+	}
+	if rule.Severity != "" && !alertSeverities[rule.Severity] {
+		return fmt.Errorf("unknown severity %q, use info, warning or critical", rule.Severity)
+	}
+	if rule.OSCAddress != "" && !strings.HasPrefix(rule.OSCAddress, "/") {
+		return fmt.Errorf("OSC address must start with /")
+	}
+	_, _, _, err := parseDerivedExpr(rule.Expression)
+	return err
+}
+
+// compileAlertRules parses the enabled rules. Derived channel references are
+// resolved against the compiled channels, so it runs again whenever they
+// change.
+func compileAlertRules() {
+	var programs []*alertProgram
+	errors := map[string]string{}
+	for _, rule := range AlertRules {
+		if !rule.Enabled {
+			continue
+		}
+		node, packets, refs, err := parseDerivedExpr(rule.Expression)
+		if err == nil {
+			err = resolveDerivedRefs(refs, packets)
+		}
+		if err != nil {
+			errors[rule.Name] = err.Error()
+			continue
+		}
+		if rule.Severity == "" {
+			rule.Severity = defaultAlertSeverity
+		}
+		if rule.OSCAddress == "" {
+			rule.OSCAddress = "/alert/" + strings.ToLower(rule.Name)
+		}
+		programs = append(programs, &alertProgram{rule: rule, node: node, packets: packets})
+	}
+	alerts.Lock()
+	defer alerts.Unlock()
+	alerts.programs = programs
+	alerts.errors = errors
+	states := map[string]*alertState{}
+	for _, p := range programs {
+		if state, ok := alerts.states[p.rule.Name]; ok {
+			states[p.rule.Name] = state
+		} else {
+			states[p.rule.Name] = &alertState{}
+		}
+	}
+	alerts.states = states
+}
+
+// alertHolds reports whether the rule's condition holds for value. An
+// active threshold rule only stops holding past the hysteresis band.
+func alertHolds(rule AlertRule, active bool, value float64) bool {
+	switch {
+	case rule.Above != nil && active:
+		return value > *rule.Above-rule.Hysteresis
+	case rule.Above != nil:
+		return value > *rule.Above
+	case rule.Below != nil && active:
+		return value < *rule.Below+rule.Hysteresis
+	case rule.Below != nil:
+		return value < *rule.Below
+	}
+	return value != 0
+}
+
+// updateAlerts evaluates the rules that read packetName
+func updateAlerts(packetName string, frame *oscFrame) {
+	env := &derivedEnv{focusCar: frame.focusCar, values: derivedValues()}
+	header := frame.header
+	var events []AlertEvent
+	var addresses []string
+	alerts.Lock()
+	alerts.lastHeader = header
+	if alerts.sessionUID != header.SessionUID {
+		// A new session starts without alerts, active ones are cleared first
+		alerts.sessionUID = header.SessionUID
+		events, addresses = resetAlerts(nil, header)
+	}
+	for _, p := range alerts.programs {
+		if !p.packets[packetName] {
+			continue
+		}
+		value, err := p.node.eval(env)
+		if err != nil || math.IsNaN(value) {
+			continue // e.g. a packet that hasn't arrived yet
+		}
+		state := alerts.states[p.rule.Name]
+		state.evaluated, state.value = true, value
+		holds := alertHolds(p.rule, state.active, value)
+		switch {
+		case holds && !state.active:
+			if !state.pending || header.SessionTime < state.pendingSince {
+				state.pending, state.pendingSince = true, header.SessionTime
+			}
+			if float64(header.SessionTime-state.pendingSince)*1000 < float64(p.rule.MinDurationMS) {
+				continue
+			}
+			state.active, state.pending, state.raisedAt = true, false, header.SessionTime
+			events = append(events, newAlertEvent(p.rule, "raised", value, header))
+			addresses = append(addresses, p.rule.OSCAddress)
+		case !holds && state.active:
+			state.active = false
+			events = append(events, newAlertEvent(p.rule, "cleared", value, header))
+			addresses = append(addresses, p.rule.OSCAddress)
+		case !holds:
+			state.pending = false
+		}
+	}
+	logAlertEvents(events)
+	alerts.Unlock()
+
+	publishAlertEvents(frame, events, addresses)
+}
+
+// resetAlerts forgets the state of the named rules, or of every rule if
+// names is nil. Active ones get a "cleared" event so receivers latched on
+// the raised state see it end. Expects alerts to be locked.
+func resetAlerts(names map[string]bool, header PacketHeader) (events []AlertEvent, addresses []string) {
+	for _, p := range alerts.programs {
+		if names != nil && !names[p.rule.Name] {
+			continue
+		}
+		if state := alerts.states[p.rule.Name]; state.active {
+			events = append(events, newAlertEvent(p.rule, "cleared", state.value, header))
+			addresses = append(addresses, p.rule.OSCAddress)
+		}
+		alerts.states[p.rule.Name] = &alertState{}
+	}
+	return events, addresses
+}
+
+// logAlertEvents expects alerts to be locked
+func logAlertEvents(events []AlertEvent) {
+	alerts.log = append(alerts.log, events...)
+	if len(alerts.log) > alertLogSize {
+		alerts.log = append([]AlertEvent(nil), alerts.log[len(alerts.log)-alertLogSize:]...)
+	}
+}
+
+func publishAlertEvents(frame *oscFrame, events []AlertEvent, addresses []string) {
+	for i, event := range events {
+		publishEvent(frame.header, "alert", event)
+		raised := int32(0)
+		if event.State == "raised" {
+			raised = 1
+		}
+		frame.sendAddress(addresses[i], raised, float32(event.Value), event.Severity, event.Message)
+	}
+}
+
+// clearAlertRule clears a rule that is changed or removed through the API,
+// a changed rule is evaluated from scratch with its new settings
+func clearAlertRule(name string) {
+	alerts.Lock()
+	header := alerts.lastHeader
+	events, addresses := resetAlerts(map[string]bool{name: true}, header)
+	logAlertEvents(events)
+	alerts.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	// Published like packet output, which it would otherwise interleave with
+	packetHandlerMu.Lock()
+	defer packetHandlerMu.Unlock()
+	frame := newOSCFrame(header, "Alert")
+	publishAlertEvents(frame, events, addresses)
+	frame.flush()
+}
+
+func newAlertEvent(rule AlertRule, state string, value float64, header PacketHeader) AlertEvent {
+	message := rule.Message
+	if message == "" {
+		message = rule.Name
+	}
+	message = strings.ReplaceAll(message, "{value}", strconv.FormatFloat(value, 'f', -1, 64))
+	return AlertEvent{
+		Rule:     rule.Name,
+		State:    state,
+		Severity: rule.Severity,
+		Value:    value,
+		Message:  message,
+		Frame:    header.FrameIdentifier,
+		Time:     header.SessionTime,
+	}
+}
+
+// rollbackAlerts restarts pending minimum durations after a rewind, raised
+// alerts stay raised until their condition clears
+func rollbackAlerts(header PacketHeader, toSessionTime float32) {
+	alerts.Lock()
+	defer alerts.Unlock()
+	for _, state := range alerts.states {
+		state.pending = false
+	}
+}
+
+type alertRuleStatus struct {
+	AlertRule
+	Active bool     `json:"active"`
+	Value  *float64 `json:"value,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+func alertRuleStatusOf(rule AlertRule) alertRuleStatus {
+	status := alertRuleStatus{AlertRule: rule}
+	alerts.Lock()
+	defer alerts.Unlock()
+	status.Error = alerts.errors[rule.Name]
+	if state, ok := alerts.states[rule.Name]; ok && state.evaluated {
+		status.Active = state.active
+		value := state.value
+		status.Value = &value
+	}
+	return status
+}
+
+type activeAlert struct {
+	Rule     string  `json:"rule"`
+	Severity string  `json:"severity"`
+	Value    float64 `json:"value"`
+	Since    float32 `json:"since"` // session time it was raised at
+}
+
+type alertsResponse struct {
+	Active []activeAlert `json:"active"`
+	Log    []AlertEvent  `json:"log"` // newest first
+}
+
+// REST API for alerts:
+//
+//	GET     /api/alerts            active alerts and the alert log
+//	DELETE  /api/alerts            clears the log
+//	GET/POST                       /api/alert-rules
+//	GET/PUT/DELETE                 /api/alert-rules/{name}
+func handleAlertsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] Alerts API handler crashed: %v", r)
+		}
+	}()
+
+	switch r.Method {
+	case http.MethodGet:
+		resp := alertsResponse{Active: []activeAlert{}, Log: []AlertEvent{}}
+		alerts.Lock()
+		for _, p := range alerts.programs {
+			if state := alerts.states[p.rule.Name]; state.active {
+				resp.Active = append(resp.Active, activeAlert{Rule: p.rule.Name, Severity: p.rule.Severity, Value: state.value, Since: state.raisedAt})
+			}
+		}
+		for i := len(alerts.log) - 1; i >= 0; i-- {
+			resp.Log = append(resp.Log, alerts.log[i])
+		}
+		alerts.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	case http.MethodDelete:
+		alerts.Lock()
+		alerts.log = nil
+		alerts.Unlock()
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleAlertRulesAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] AlertRules API handler crashed: %v", r)
+		}
+	}()
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/alert-rules"), "/")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	alertRulesMu.Lock()
+	defer alertRulesMu.Unlock()
+	if name == "" {
+		switch r.Method {
+		case http.MethodGet:
+			statuses := make([]alertRuleStatus, 0, len(AlertRules))
+			for _, rule := range AlertRules {
+				statuses = append(statuses, alertRuleStatusOf(rule))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(statuses)
+		case http.MethodPost:
+			var rule AlertRule
+			err := json.Unmarshal(body, &rule)
+			if err == nil {
+				err = validateAlertRule(rule)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if findAlertRule(rule.Name) >= 0 {
+				http.Error(w, "rule already exists", http.StatusConflict)
+				return
+			}
+			if rule.Severity == "" {
+				rule.Severity = defaultAlertSeverity
+			}
+			AlertRules = append(AlertRules, rule)
+			SaveAlertRulesConfig()
+			compileAlertRules()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(alertRuleStatusOf(rule))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	idx := findAlertRule(name)
+	if idx < 0 || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alertRuleStatusOf(AlertRules[idx]))
+		return
+	case http.MethodPut:
+		rule := AlertRules[idx]
+		err := json.Unmarshal(body, &rule)
+		if err == nil {
+			err = validateAlertRule(rule)
+		}
+		if err == nil && !strings.EqualFold(rule.Name, AlertRules[idx].Name) && findAlertRule(rule.Name) >= 0 {
+			err = fmt.Errorf("rule %q already exists", rule.Name)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clearAlertRule(AlertRules[idx].Name)
+		AlertRules[idx] = rule
+	case http.MethodDelete:
+		clearAlertRule(AlertRules[idx].Name)
+		AlertRules = append(AlertRules[:idx], AlertRules[idx+1:]...)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	SaveAlertRulesConfig()
+	compileAlertRules()
+	w.WriteHeader(http.StatusOK)
+}
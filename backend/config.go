@@ -6,9 +6,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
-
-	"github.com/hypebeast/go-osc/osc"
 )
 
 type AppConfig struct {
@@ -19,6 +18,15 @@ type AppConfig struct {
 	EnableOSC       bool   `json:"enable_osc"`
 	BroadcastRateHz int    `json:"broadcast_rate_hz"`
 	DebugOutput     bool   `json:"debug_output"`
+	OSCQueueSize    int    `json:"osc_queue_size"`
+	OSCBundles      bool   `json:"osc_bundles"`
+	// WebSocket send queue per client and what to do when it is full
+	WSQueueSize        int    `json:"ws_queue_size"`
+	WSSlowClientPolicy string `json:"ws_slow_client_policy"`
+	// Publish rate of the timing tower
+	TimingTowerRateHz int `json:"timing_tower_rate_hz"`
+	// Car followed by single-car OSC mappings and the telemetry summary
+	FocusCar FocusCarConfig `json:"focus_car"`
 }
 
 var Config AppConfig
@@ -37,13 +45,19 @@ func InitConfig() {
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		// Initial setup - ask or use defaults
 		Config = AppConfig{
-			UDPAddr:         "127.0.0.1",
-			UDPPort:         20777,
-			OSCAddr:         "127.0.0.1",
-			OSCPort:         9000,
-			EnableOSC:       false,
-			BroadcastRateHz: 2,     // Default to 2Hz
-			DebugOutput:     false, // Default to no debug output
+			UDPAddr:            "127.0.0.1",
+			UDPPort:            20777,
+			OSCAddr:            "127.0.0.1",
+			OSCPort:            9000,
+			EnableOSC:          false,
+			BroadcastRateHz:    2,     // Default to 2Hz
+			DebugOutput:        false, // Default to no debug output
+			OSCQueueSize:       defaultOSCQueueSize,
+			OSCBundles:         false, // One OSC message per field unless enabled
+			WSQueueSize:        defaultWSQueueSize,
+			WSSlowClientPolicy: WSSlowClientDrop,
+			TimingTowerRateHz:  defaultTimingTowerRateHz,
+			FocusCar:           FocusCarConfig{Mode: FocusPlayer},
 		}
 		SaveConfig()
 	} else {
@@ -76,13 +90,56 @@ func LoadConfig() {
 }
 
 var oscClientMu sync.Mutex
-var oscClient *osc.Client
+var oscSenders = map[string]*oscSender{}
 
+// restartOSCService recreates one sender per enabled OSC destination
 func restartOSCService() {
+	// Copied first, oscClientMu is never held while taking oscDestinationsMu
+	oscDestinationsMu.RLock()
+	destinations := append([]OSCDestination(nil), OSCDestinations...)
+	oscDestinationsMu.RUnlock()
+
 	oscClientMu.Lock()
 	defer oscClientMu.Unlock()
-	oscClient = osc.NewClient(Config.OSCAddr, Config.OSCPort)
-	log.Printf("[service] OSC restarted at %s:%d", Config.OSCAddr, Config.OSCPort)
+	for name, sender := range oscSenders {
+		sender.close()
+		delete(oscSenders, name)
+	}
+	for _, dest := range destinations {
+		if !dest.Enabled {
+			continue
+		}
+		sender, err := newOSCSender(dest.Addr, dest.Port, Config.OSCQueueSize)
+		if err != nil {
+			log.Printf("[error] Could not start OSC sender %q for %s:%d: %v", dest.Name, dest.Addr, dest.Port, err)
+			continue
+		}
+		oscSenders[dest.Name] = sender
+		log.Printf("[service] OSC %q restarted at %s:%d", dest.Name, dest.Addr, dest.Port)
+	}
+}
+
+// API for OSC sender queue/drop counters, one entry per destination
+func handleOSCStatsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] OSCStats API handler crashed: %v", r)
+		}
+	}()
+
+	oscClientMu.Lock()
+	stats := make([]oscSenderStats, 0, len(oscSenders))
+	for name, sender := range oscSenders {
+		stats = append(stats, sender.stats(name))
+	}
+	oscClientMu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
 }
 
 func handleConfigAPI(w http.ResponseWriter, r *http.Request) {
@@ -108,6 +165,10 @@ func handleConfigAPI(w http.ResponseWriter, r *http.Request) {
 		}
 		if oldConfig.OSCAddr != Config.OSCAddr || oldConfig.OSCPort != Config.OSCPort {
 			log.Printf("[config] OSC address/port changed: %s:%d -> %s:%d", oldConfig.OSCAddr, oldConfig.OSCPort, Config.OSCAddr, Config.OSCPort)
+			syncDefaultOSCDestination()
+			restartOSCService()
+		} else if oldConfig.OSCQueueSize != Config.OSCQueueSize {
+			log.Printf("[config] OSC queue size changed: %d -> %d", oldConfig.OSCQueueSize, Config.OSCQueueSize)
 			restartOSCService()
 		}
 		if oldConfig.EnableOSC != Config.EnableOSC {
@@ -294,6 +355,9 @@ type OSCAddressEntry struct {
 	ValueType string `json:"type"`
 	Enabled   bool   `json:"enabled"`
 	AllowZero bool   `json:"allowZero"`
+	Label     bool   `json:"label,omitempty"` // append the display name of enum ids as a string argument
+
+	Transforms []OSCTransform `json:"transforms,omitempty"` // applied in order before sending, see OSCTransform
 }
 
 var OSCAddresses = map[string]OSCAddressEntry{
@@ -329,6 +393,8 @@ var OSCAddresses = map[string]OSCAddressEntry{
 	"SurfaceTypeRR":             {Address: "/car/surface_type/rr", ValueType: "int", Enabled: true},
 	"SurfaceTypeFL":             {Address: "/car/surface_type/fl", ValueType: "int", Enabled: true},
 	"SurfaceTypeFR":             {Address: "/car/surface_type/fr", ValueType: "int", Enabled: true},
+	"MFDPanelIndex":             {Address: "/car/mfd_panel_index", ValueType: "int", Enabled: true},
+	"SuggestedGear":             {Address: "/car/suggested_gear", ValueType: "int", Enabled: true},
 
 	// Motion (player car only, but can be extended for all cars)
 	"WorldPositionX":     {Address: "/motion/world_pos/x", ValueType: "float", Enabled: true},
@@ -604,6 +670,39 @@ var OSCAddresses = map[string]OSCAddressEntry{
 	"LapPositions_Lap2_Car0": {Address: "/lappositions/lap2/car0", ValueType: "int", Enabled: true},
 	"LapPositions_Lap2_Car1": {Address: "/lappositions/lap2/car1", ValueType: "int", Enabled: true},
 	"LapPositions_Lap2_Car2": {Address: "/lappositions/lap2/car2", ValueType: "int", Enabled: true},
+	// Events (arguments are the event detail fields in spec order)
+	"Event_session_started":      {Address: "/event/session_started", ValueType: "event", Enabled: true},
+	"Event_session_ended":        {Address: "/event/session_ended", ValueType: "event", Enabled: true},
+	"Event_fastest_lap":          {Address: "/event/fastest_lap", ValueType: "event", Enabled: true},
+	"Event_retirement":           {Address: "/event/retirement", ValueType: "event", Enabled: true},
+	"Event_drs_enabled":          {Address: "/event/drs_enabled", ValueType: "event", Enabled: true},
+	"Event_drs_disabled":         {Address: "/event/drs_disabled", ValueType: "event", Enabled: true},
+	"Event_team_mate_in_pits":    {Address: "/event/team_mate_in_pits", ValueType: "event", Enabled: true},
+	"Event_chequered_flag":       {Address: "/event/chequered_flag", ValueType: "event", Enabled: true},
+	"Event_race_winner":          {Address: "/event/race_winner", ValueType: "event", Enabled: true},
+	"Event_penalty":              {Address: "/event/penalty", ValueType: "event", Enabled: true},
+	"Event_speed_trap":           {Address: "/event/speed_trap", ValueType: "event", Enabled: true},
+	"Event_start_lights":         {Address: "/event/start_lights", ValueType: "event", Enabled: true},
+	"Event_lights_out":           {Address: "/event/lights_out", ValueType: "event", Enabled: true},
+	"Event_drive_through_served": {Address: "/event/drive_through_served", ValueType: "event", Enabled: true},
+	"Event_stop_go_served":       {Address: "/event/stop_go_served", ValueType: "event", Enabled: true},
+	"Event_flashback":            {Address: "/event/flashback", ValueType: "event", Enabled: true},
+	"Event_buttons":              {Address: "/event/buttons", ValueType: "event", Enabled: false},
+	"Event_red_flag":             {Address: "/event/red_flag", ValueType: "event", Enabled: true},
+	"Event_overtake":             {Address: "/event/overtake", ValueType: "event", Enabled: true},
+	"Event_safety_car":           {Address: "/event/safety_car", ValueType: "event", Enabled: true},
+	"Event_collision":            {Address: "/event/collision", ValueType: "event", Enabled: true},
+	// Session lifecycle, see session.go
+	"Event_session_new":      {Address: "/event/session_new", ValueType: "event", Enabled: true},
+	"Event_session_complete": {Address: "/event/session_complete", ValueType: "event", Enabled: true},
+	"Event_session_restart":  {Address: "/event/session_restart", ValueType: "event", Enabled: true},
+	"Event_rewind":           {Address: "/event/rewind", ValueType: "event", Enabled: true},
+	"Event_lap_completed":    {Address: "/event/lap_completed", ValueType: "event", Enabled: true},
+	"Event_alert":            {Address: "/event/alert", ValueType: "event", Enabled: false},
+	// Race result, the list of results is only sent to WebSocket and webhooks
+	"Event_final_classification": {Address: "/event/final_classification", ValueType: "event", Enabled: true},
+	// One message per timing tower row: position, car index, name, gap, interval, last lap, best lap, pit stops, tyre compound
+	"TimingTower_Row": {Address: "/tower/row", ValueType: "event", Enabled: true},
 }
 
 func InitOSCAddressesConfig() {
@@ -645,24 +744,3 @@ func LoadOSCAddressesConfig() {
 		log.Printf("[error] Could not decode OSC addresses config: %v", err)
 	}
 }
-
-// API for getting/setting OSC address mapping
-func handleOSCAddressesAPI(w http.ResponseWriter, r *http.Request) {
-	setCORS(w, r)
-	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
-		return
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("[panic] OSCAddresses API handler crashed: %v", r)
-		}
-	}()
-
-	if r.Method == http.MethodGet {
-		json.NewEncoder(w).Encode(OSCAddresses)
-	} else if r.Method == http.MethodPost {
-		json.NewDecoder(r.Body).Decode(&OSCAddresses)
-		SaveOSCAddressesConfig()
-		w.WriteHeader(http.StatusOK)
-	}
-}
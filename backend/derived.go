@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DerivedChannel is a named expression over decoded fields (see
+// derived_expr.go). Its value is published as WebSocket topic
+// "Derived/<name>" and can be mapped to OSC as "Derived.<name>", whenever a
+// packet it reads arrives.
+type DerivedChannel struct {
+	Name        string `json:"name"`
+	Expression  string `json:"expression"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// Pseudo-packet name of derived channels in mappings and the live state
+const derivedPacket = "Derived"
+
+var derivedNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+var DerivedChannels = []DerivedChannel{}
+var derivedChannelsConfigPath string
+
+// derivedProgram is a compiled, enabled channel
+type derivedProgram struct {
+	name    string
+	node    derivedNode
+	packets map[string]bool // packets it reads, including through other channels
+}
+
+// Compiled channels in evaluation order (dependencies first) and their
+// latest values
+var derived struct {
+	sync.RWMutex
+	programs []*derivedProgram
+	errors   map[string]string // channel name -> compile error
+	values   map[string]float64
+}
+
+func InitDerivedChannelsConfig() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	appDir := filepath.Join(configDir, "f1-telem-bridge")
+	os.MkdirAll(appDir, 0755)
+	derivedChannelsConfigPath = filepath.Join(appDir, "derived_channels.json")
+
+	if _, err := os.Stat(derivedChannelsConfigPath); os.IsNotExist(err) {
+		SaveDerivedChannelsConfig()
+	} else {
+		LoadDerivedChannelsConfig()
+	}
+	derived.Lock()
+	compileDerivedChannels()
+	for name, err := range derived.errors {
+		log.Printf("[config] Derived channel %s is invalid: %s", name, err)
+	}
+	derived.Unlock()
+}
+
+func SaveDerivedChannelsConfig() {
+	f, err := os.Create(derivedChannelsConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not create derived channels config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(DerivedChannels); err != nil {
+		log.Printf("[error] Could not encode derived channels config: %v", err)
+	}
+}
+
+func LoadDerivedChannelsConfig() {
+	f, err := os.Open(derivedChannelsConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not open derived channels config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&DerivedChannels); err != nil {
+		log.Printf("[error] Could not decode derived channels config: %v", err)
+	}
+}
+
+func findDerivedChannel(name string) int {
+	for i, c := range DerivedChannels {
+		if strings.EqualFold(c.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// compileDerivedChannels parses every enabled channel and orders them so
+// each channel comes after the ones it reads. Channels with errors, unknown
+// references or reference cycles are left out. Expects derived to be locked.
+func compileDerivedChannels() {
+	type parsed struct {
+		node    derivedNode
+		packets map[string]bool
+		refs    map[string][]*derivedRef
+	}
+	all := map[string]*parsed{}
+	canonical := map[string]string{} // lower case -> configured name
+	errors := map[string]string{}
+	for _, c := range DerivedChannels {
+		if !c.Enabled {
+			continue
+		}
+		node, packets, refs, err := parseDerivedExpr(c.Expression)
+		if err != nil {
+			errors[c.Name] = err.Error()
+			continue
+		}
+		all[c.Name] = &parsed{node, packets, refs}
+		canonical[strings.ToLower(c.Name)] = c.Name
+	}
+
+	// Depth-first ordering, a channel is ready once all its references are
+	var programs []*derivedProgram
+	byName := map[string]*derivedProgram{}
+	state := map[string]int{} // 1 visiting, 2 done, 3 failed
+	failed := map[string]error{}
+	var visit func(name string) error
+	visit = func(name string) (err error) {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("reference cycle through Derived.%s", name)
+		case 2:
+			return nil
+		case 3:
+			return failed[name]
+		}
+		p, ok := all[name]
+		if !ok {
+			return fmt.Errorf("Derived.%s is not an enabled, valid channel", name)
+		}
+		state[name] = 1
+		// A channel that failed inside another one's visit keeps its own
+		// error, instead of looking like a cycle when visited again
+		defer func() {
+			if err != nil {
+				state[name], failed[name] = 3, err
+			}
+		}()
+		packets := map[string]bool{}
+		for packet := range p.packets {
+			packets[packet] = true
+		}
+		for lower, nodes := range p.refs {
+			ref, ok := canonical[lower]
+			if !ok {
+				return fmt.Errorf("Derived.%s is not an enabled, valid channel", nodes[0].name)
+			}
+			for _, node := range nodes {
+				node.name = ref
+			}
+			if err := visit(ref); err != nil {
+				return err
+			}
+			for packet := range byName[ref].packets {
+				packets[packet] = true
+			}
+		}
+		state[name] = 2
+		program := &derivedProgram{name: name, node: p.node, packets: packets}
+		programs = append(programs, program)
+		byName[name] = program
+		return nil
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			errors[name] = err.Error()
+			// Dependents visited later report it as invalid
+			delete(all, name)
+			delete(canonical, strings.ToLower(name))
+		}
+	}
+	derived.programs = programs
+	derived.errors = errors
+	if derived.values == nil {
+		derived.values = map[string]float64{}
+	}
+	for name := range derived.values {
+		if byName[name] == nil {
+			delete(derived.values, name)
+		}
+	}
+}
+
+// derivedChannelName returns the configured spelling of an enabled, valid
+// channel. Names are case-insensitive like field paths.
+func derivedChannelName(name string) (string, bool) {
+	derived.RLock()
+	defer derived.RUnlock()
+	for _, p := range derived.programs {
+		if strings.EqualFold(p.name, name) {
+			return p.name, true
+		}
+	}
+	return "", false
+}
+
+// resolveDerivedRefs points the Derived.X references of an expression
+// compiled outside this file at the channels, and adds the packets those
+// channels read to packets
+func resolveDerivedRefs(refs map[string][]*derivedRef, packets map[string]bool) error {
+	derived.RLock()
+	defer derived.RUnlock()
+	for _, nodes := range refs {
+		var program *derivedProgram
+		for _, p := range derived.programs {
+			if strings.EqualFold(p.name, nodes[0].name) {
+				program = p
+			}
+		}
+		if program == nil {
+			return fmt.Errorf("Derived.%s is not an enabled, valid channel", nodes[0].name)
+		}
+		for _, node := range nodes {
+			node.name = program.name
+		}
+		for packet := range program.packets {
+			packets[packet] = true
+		}
+	}
+	return nil
+}
+
+// derivedValues is a copy of the latest channel values
+func derivedValues() map[string]float64 {
+	derived.RLock()
+	defer derived.RUnlock()
+	values := make(map[string]float64, len(derived.values))
+	for name, v := range derived.values {
+		values[name] = v
+	}
+	return values
+}
+
+// updateDerivedChannels evaluates the channels that read packetName and
+// publishes the ones that have a value
+func updateDerivedChannels(packetName string, frame *oscFrame) {
+	derived.Lock()
+	env := &derivedEnv{focusCar: frame.focusCar, values: derived.values}
+	updated := map[string]float64{}
+	for _, p := range derived.programs {
+		if !p.packets[packetName] {
+			continue
+		}
+		v, err := p.node.eval(env)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue // e.g. a packet that hasn't arrived yet, or a division by zero
+		}
+		derived.values[p.name] = v
+		updated[p.name] = v
+	}
+	var snapshot map[string]float64
+	if len(updated) > 0 {
+		snapshot = make(map[string]float64, len(derived.values))
+		for name, v := range derived.values {
+			snapshot[name] = v
+		}
+	}
+	derived.Unlock()
+	if len(updated) == 0 {
+		return
+	}
+
+	storeState(derivedPacket, snapshot)
+	for name, v := range updated {
+		// Unlike packet fields 0 is published, channels are often flags
+		key := derivedPacket + "/" + name
+		if changedSince(key, v, lastSentWS) {
+			publishWS(frame.header, key, -1, v)
+			updateLastSent(key, v, lastSentWS)
+		}
+	}
+	sendPacketToOSC(derivedPacket, reflect.ValueOf(updated), -1, frame)
+}
+
+// reloadDerivedChannels recompiles the channels and the alert rules and OSC
+// mappings that may refer to them, after DerivedChannels changed
+func reloadDerivedChannels() {
+	derived.Lock()
+	compileDerivedChannels()
+	derived.Unlock()
+	alertRulesMu.Lock()
+	compileAlertRules()
+	alertRulesMu.Unlock()
+	oscDestinationsMu.Lock()
+	rebuildOSCBindings()
+	oscDestinationsMu.Unlock()
+}
+
+func validateDerivedChannel(c DerivedChannel) error {
+	if !derivedNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("invalid channel name %q, use letters and digits", c.Name)
+	}
+	if strings.EqualFold(c.Name, "validate") {
+		return fmt.Errorf("channel name %q is reserved", c.Name)
+	}
+	_, _, _, err := parseDerivedExpr(c.Expression)
+	return err
+}
+
+type derivedChannelStatus struct {
+	DerivedChannel
+	Value *float64 `json:"value,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+func derivedChannelStatusOf(c DerivedChannel) derivedChannelStatus {
+	status := derivedChannelStatus{DerivedChannel: c}
+	derived.RLock()
+	defer derived.RUnlock()
+	status.Error = derived.errors[c.Name]
+	if v, ok := derived.values[c.Name]; ok {
+		status.Value = &v
+	}
+	return status
+}
+
+type derivedValidateRequest struct {
+	Expression string `json:"expression"`
+}
+
+type derivedValidateResponse struct {
+	Valid    bool     `json:"valid"`
+	Error    string   `json:"error,omitempty"`
+	Position *int     `json:"position,omitempty"` // byte offset of a syntax error
+	Packets  []string `json:"packets"`            // packets the expression reads
+	Refs     []string `json:"refs"`               // derived channels it reads
+	Value    *float64 `json:"value,omitempty"`    // evaluated against the live state
+}
+
+// Serializes changes to DerivedChannels, packet handling only reads the
+// compiled programs
+var derivedChannelsMu sync.Mutex
+
+// REST API for derived channels:
+//
+//	GET/POST            /api/derived-channels
+//	GET/PUT/DELETE      /api/derived-channels/{name}
+//	POST                /api/derived-channels/validate {"expression": "..."}
+func handleDerivedChannelsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] DerivedChannels API handler crashed: %v", r)
+		}
+	}()
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/derived-channels"), "/")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case name == "":
+		handleDerivedChannelList(w, r, body)
+	case name == "validate":
+		handleDerivedChannelValidate(w, r, body)
+	case strings.Contains(name, "/"):
+		http.NotFound(w, r)
+	default:
+		handleDerivedChannel(w, r, name, body)
+	}
+}
+
+func handleDerivedChannelList(w http.ResponseWriter, r *http.Request, body []byte) {
+	derivedChannelsMu.Lock()
+	defer derivedChannelsMu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		statuses := make([]derivedChannelStatus, 0, len(DerivedChannels))
+		for _, c := range DerivedChannels {
+			statuses = append(statuses, derivedChannelStatusOf(c))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	case http.MethodPost:
+		var c DerivedChannel
+		err := json.Unmarshal(body, &c)
+		if err == nil {
+			err = validateDerivedChannel(c)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if findDerivedChannel(c.Name) >= 0 {
+			http.Error(w, "channel already exists", http.StatusConflict)
+			return
+		}
+		DerivedChannels = append(DerivedChannels, c)
+		SaveDerivedChannelsConfig()
+		reloadDerivedChannels()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(derivedChannelStatusOf(c))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleDerivedChannel(w http.ResponseWriter, r *http.Request, name string, body []byte) {
+	derivedChannelsMu.Lock()
+	defer derivedChannelsMu.Unlock()
+	idx := findDerivedChannel(name)
+	if idx < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(derivedChannelStatusOf(DerivedChannels[idx]))
+		return
+	case http.MethodPut:
+		c := DerivedChannels[idx]
+		err := json.Unmarshal(body, &c)
+		if err == nil {
+			err = validateDerivedChannel(c)
+		}
+		if err == nil && !strings.EqualFold(c.Name, DerivedChannels[idx].Name) && findDerivedChannel(c.Name) >= 0 {
+			err = fmt.Errorf("channel %q already exists", c.Name)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		DerivedChannels[idx] = c
+	case http.MethodDelete:
+		DerivedChannels = append(DerivedChannels[:idx], DerivedChannels[idx+1:]...)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	SaveDerivedChannelsConfig()
+	reloadDerivedChannels()
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleDerivedChannelValidate(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req derivedValidateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := derivedValidateResponse{Packets: []string{}, Refs: []string{}}
+	node, packets, refs, err := parseDerivedExpr(req.Expression)
+	if err != nil {
+		resp.Error = err.Error()
+		if syntaxErr, ok := err.(*derivedExprError); ok {
+			resp.Position = &syntaxErr.Pos
+		}
+	} else {
+		resp.Valid = true
+		for packet := range packets {
+			resp.Packets = append(resp.Packets, packet)
+		}
+		if err := resolveDerivedRefs(refs, map[string]bool{}); err != nil {
+			resp.Valid = false
+			resp.Error = err.Error()
+		}
+		for _, nodes := range refs {
+			resp.Refs = append(resp.Refs, nodes[0].name)
+		}
+		sort.Strings(resp.Packets)
+		sort.Strings(resp.Refs)
+		if resp.Valid {
+			focusState.Lock()
+			focusCar := resolveFocusCar(focusState.lastPlayer)
+			focusState.Unlock()
+			values := derivedValues()
+			if v, err := node.eval(&derivedEnv{focusCar: focusCar, values: values}); err == nil && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				resp.Value = &v
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
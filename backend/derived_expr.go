@@ -0,0 +1,543 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Derived channel expressions are arithmetic over packet field paths (see
+// osc_paths.go) and other derived channels:
+//
+//	avg(CarTelemetry.TyresSurfaceTemperature[0], CarTelemetry.TyresSurfaceTemperature[1])
+//	CarTelemetry.Speed / 3.6
+//	CarTelemetry.DRS && CarStatus.DRSAllowed
+//	hypot(Motion.GForceLateral, Motion.GForceLongitudinal)
+//	Derived.SpeedMS > 80 ? 1 : 0
+//
+// Everything evaluates to a float64, comparisons and logic give 0 or 1.
+
+// derivedExprError is a syntax error with the byte offset it was found at
+type derivedExprError struct {
+	Pos int
+	Msg string
+}
+
+func (e *derivedExprError) Error() string {
+	return fmt.Sprintf("at %d: %s", e.Pos, e.Msg)
+}
+
+type derivedTokenKind int
+
+const (
+	tokEOF derivedTokenKind = iota
+	tokNumber
+	tokIdent // a field path or function name
+	tokOp
+)
+
+type derivedToken struct {
+	kind derivedTokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+var derivedOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "+", "-", "*", "/", "%", "^", "<", ">", "!", "?", ":", "(", ")", ","}
+
+func lexDerivedExpr(src string) ([]derivedToken, error) {
+	var tokens []derivedToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, &derivedExprError{start, fmt.Sprintf("invalid number %q", src[start:i])}
+			}
+			tokens = append(tokens, derivedToken{kind: tokNumber, num: n, text: src[start:i], pos: start})
+		case isDerivedIdentStart(c):
+			// A path runs over dots and bracketed indexes: LapData[focus].CarPosition
+			start := i
+			for i < len(src) {
+				if isDerivedIdentStart(src[i]) || src[i] >= '0' && src[i] <= '9' || src[i] == '.' {
+					i++
+				} else if src[i] == '[' {
+					end := strings.IndexByte(src[i:], ']')
+					if end < 0 {
+						return nil, &derivedExprError{i, "missing ]"}
+					}
+					i += end + 1
+				} else {
+					break
+				}
+			}
+			tokens = append(tokens, derivedToken{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			op := ""
+			for _, candidate := range derivedOperators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, &derivedExprError{i, fmt.Sprintf("unexpected %q", c)}
+			}
+			tokens = append(tokens, derivedToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, derivedToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+func isDerivedIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// -------------------- AST --------------------
+
+type derivedEnv struct {
+	focusCar int
+	values   map[string]float64 // derived channels evaluated so far
+}
+
+type derivedNode interface {
+	eval(env *derivedEnv) (float64, error)
+}
+
+type derivedNumber float64
+
+func (n derivedNumber) eval(*derivedEnv) (float64, error) { return float64(n), nil }
+
+// derivedField reads a packet field from the live state
+type derivedField struct {
+	text string
+	path *oscPath
+}
+
+func (n *derivedField) eval(env *derivedEnv) (float64, error) {
+	value, ok := liveOSCValue(n.path, env.focusCar)
+	if !ok {
+		return 0, fmt.Errorf("%s has no value yet", n.text)
+	}
+	v, ok := oscNumber(value)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a number", n.text)
+	}
+	return v, nil
+}
+
+// derivedRef reads another derived channel
+type derivedRef struct {
+	name string
+}
+
+func (n *derivedRef) eval(env *derivedEnv) (float64, error) {
+	v, ok := env.values[n.name]
+	if !ok {
+		return 0, fmt.Errorf("Derived.%s has no value yet", n.name)
+	}
+	return v, nil
+}
+
+type derivedUnary struct {
+	op      string
+	operand derivedNode
+}
+
+func (n *derivedUnary) eval(env *derivedEnv) (float64, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if n.op == "!" {
+		return derivedBool(v == 0), nil
+	}
+	return -v, nil
+}
+
+type derivedBinary struct {
+	op          string
+	left, right derivedNode
+}
+
+func (n *derivedBinary) eval(env *derivedEnv) (float64, error) {
+	a, err := n.left.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	// && and || short-circuit
+	switch {
+	case n.op == "&&" && a == 0:
+		return 0, nil
+	case n.op == "||" && a != 0:
+		return 1, nil
+	}
+	b, err := n.right.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	case "%":
+		return math.Mod(a, b), nil
+	case "^":
+		return math.Pow(a, b), nil
+	case "<":
+		return derivedBool(a < b), nil
+	case "<=":
+		return derivedBool(a <= b), nil
+	case ">":
+		return derivedBool(a > b), nil
+	case ">=":
+		return derivedBool(a >= b), nil
+	case "==":
+		return derivedBool(a == b), nil
+	case "!=":
+		return derivedBool(a != b), nil
+	}
+	return derivedBool(b != 0), nil // the right side of && and ||
+}
+
+type derivedTernary struct {
+	cond, then, otherwise derivedNode
+}
+
+func (n *derivedTernary) eval(env *derivedEnv) (float64, error) {
+	c, err := n.cond.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if c != 0 {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+
+type derivedCall struct {
+	fn   derivedFunc
+	args []derivedNode
+}
+
+func (n *derivedCall) eval(env *derivedEnv) (float64, error) {
+	if n.fn.lazy != nil {
+		return n.fn.lazy(env, n.args)
+	}
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	return n.fn.call(args), nil
+}
+
+func derivedBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// -------------------- Functions --------------------
+
+type derivedFunc struct {
+	minArgs, maxArgs int // maxArgs -1 for variadic
+	call             func(args []float64) float64
+	lazy             func(env *derivedEnv, args []derivedNode) (float64, error) // evaluates its own arguments
+}
+
+func derivedMath1(f func(float64) float64) derivedFunc {
+	return derivedFunc{minArgs: 1, maxArgs: 1, call: func(a []float64) float64 { return f(a[0]) }}
+}
+
+var derivedFuncs = map[string]derivedFunc{
+	"abs":   derivedMath1(math.Abs),
+	"sqrt":  derivedMath1(math.Sqrt),
+	"round": derivedMath1(math.Round),
+	"floor": derivedMath1(math.Floor),
+	"ceil":  derivedMath1(math.Ceil),
+	"sin":   derivedMath1(math.Sin),
+	"cos":   derivedMath1(math.Cos),
+	"tan":   derivedMath1(math.Tan),
+	"log":   derivedMath1(math.Log),
+	"exp":   derivedMath1(math.Exp),
+	"sign": derivedMath1(func(v float64) float64 {
+		if v == 0 {
+			return 0
+		}
+		return math.Copysign(1, v)
+	}),
+	"pow":   {minArgs: 2, maxArgs: 2, call: func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"atan2": {minArgs: 2, maxArgs: 2, call: func(a []float64) float64 { return math.Atan2(a[0], a[1]) }},
+	"clamp": {minArgs: 3, maxArgs: 3, call: func(a []float64) float64 { return math.Min(math.Max(a[0], a[1]), a[2]) }},
+	"min": {minArgs: 1, maxArgs: -1, call: func(a []float64) float64 {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	}},
+	"max": {minArgs: 1, maxArgs: -1, call: func(a []float64) float64 {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	}},
+	"sum": {minArgs: 1, maxArgs: -1, call: derivedSum},
+	"avg": {minArgs: 1, maxArgs: -1, call: func(a []float64) float64 { return derivedSum(a) / float64(len(a)) }},
+	"hypot": {minArgs: 1, maxArgs: -1, call: func(a []float64) float64 {
+		sq := 0.0
+		for _, v := range a {
+			sq += v * v
+		}
+		return math.Sqrt(sq)
+	}},
+	// if(cond, then, else) only evaluates the branch it returns
+	"if": {minArgs: 3, maxArgs: 3, lazy: func(env *derivedEnv, args []derivedNode) (float64, error) {
+		return (&derivedTernary{args[0], args[1], args[2]}).eval(env)
+	}},
+}
+
+func derivedSum(a []float64) float64 {
+	sum := 0.0
+	for _, v := range a {
+		sum += v
+	}
+	return sum
+}
+
+var derivedConstants = map[string]float64{
+	"true":  1,
+	"false": 0,
+	"pi":    math.Pi,
+}
+
+// -------------------- Parser --------------------
+
+// Binding powers of the infix operators, higher binds tighter
+var derivedInfix = map[string]int{
+	"?":  1,
+	"||": 2,
+	"&&": 3,
+	"==": 4, "!=": 4,
+	"<": 5, "<=": 5, ">": 5, ">=": 5,
+	"+": 6, "-": 6,
+	"*": 7, "/": 7, "%": 7,
+	"^": 9,
+}
+
+const derivedPrefixPower = 8 // unary - and !, -x^2 is -(x^2)
+
+// derivedParser is a Pratt parser. It collects the packets and derived
+// channels the expression reads while parsing.
+type derivedParser struct {
+	tokens  []derivedToken
+	pos     int
+	packets map[string]bool
+	refs    map[string][]*derivedRef // by lower case name
+}
+
+// parseDerivedExpr compiles an expression. Derived.X references are
+// recorded by name, the caller checks they exist and sets their spelling.
+func parseDerivedExpr(src string) (node derivedNode, packets map[string]bool, refs map[string][]*derivedRef, err error) {
+	tokens, err := lexDerivedExpr(src)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := &derivedParser{tokens: tokens, packets: map[string]bool{}, refs: map[string][]*derivedRef{}}
+	if p.peek().kind == tokEOF {
+		return nil, nil, nil, &derivedExprError{0, "empty expression"}
+	}
+	node, err = p.parse(0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, nil, nil, &derivedExprError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return node, p.packets, p.refs, nil
+}
+
+func (p *derivedParser) peek() derivedToken { return p.tokens[p.pos] }
+
+func (p *derivedParser) next() derivedToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *derivedParser) expect(op string) error {
+	if t := p.next(); t.kind != tokOp || t.text != op {
+		return &derivedExprError{t.pos, fmt.Sprintf("expected %q", op)}
+	}
+	return nil
+}
+
+func (p *derivedParser) parse(minPower int) (derivedNode, error) {
+	left, err := p.parsePrefix()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		power, ok := derivedInfix[t.text]
+		if t.kind != tokOp || !ok || power <= minPower {
+			return left, nil
+		}
+		p.next()
+		if t.text == "?" {
+			// Right associative: a ? b : c ? d : e
+			then, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			otherwise, err := p.parse(power - 1)
+			if err != nil {
+				return nil, err
+			}
+			left = &derivedTernary{left, then, otherwise}
+			continue
+		}
+		rightPower := power
+		if t.text == "^" {
+			rightPower = power - 1 // right associative
+		}
+		right, err := p.parse(rightPower)
+		if err != nil {
+			return nil, err
+		}
+		left = &derivedBinary{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *derivedParser) parsePrefix() (derivedNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return derivedNumber(t.num), nil
+	case tokIdent:
+		return p.parseIdent(t)
+	case tokOp:
+		switch t.text {
+		case "-", "!":
+			operand, err := p.parse(derivedPrefixPower)
+			if err != nil {
+				return nil, err
+			}
+			return &derivedUnary{op: t.text, operand: operand}, nil
+		case "+":
+			return p.parse(derivedPrefixPower)
+		case "(":
+			inner, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+		return nil, &derivedExprError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return nil, &derivedExprError{t.pos, "unexpected end of expression"}
+}
+
+func (p *derivedParser) parseIdent(t derivedToken) (derivedNode, error) {
+	if next := p.peek(); next.kind == tokOp && next.text == "(" {
+		return p.parseCall(t)
+	}
+	if v, ok := derivedConstants[strings.ToLower(t.text)]; ok {
+		return derivedNumber(v), nil
+	}
+	if name, ok := cutDerivedPrefix(t.text); ok {
+		if !derivedNamePattern.MatchString(name) {
+			return nil, &derivedExprError{t.pos, fmt.Sprintf("invalid derived channel name %q", name)}
+		}
+		ref := &derivedRef{name: name}
+		p.refs[strings.ToLower(name)] = append(p.refs[strings.ToLower(name)], ref)
+		return ref, nil
+	}
+	path, err := oscKeyPath(t.text)
+	if err == nil {
+		var compiled *oscPath
+		if compiled, err = compileOSCPath(path); err == nil {
+			if compiled.leaf == fixedNameType {
+				return nil, &derivedExprError{t.pos, fmt.Sprintf("%s is a name, not a number", t.text)}
+			}
+			p.packets[compiled.packet] = true
+			return &derivedField{text: t.text, path: compiled}, nil
+		}
+	}
+	return nil, &derivedExprError{t.pos, err.Error()}
+}
+
+func (p *derivedParser) parseCall(t derivedToken) (derivedNode, error) {
+	fn, ok := derivedFuncs[strings.ToLower(t.text)]
+	if !ok {
+		return nil, &derivedExprError{t.pos, fmt.Sprintf("unknown function %s", t.text)}
+	}
+	p.next() // (
+	var args []derivedNode
+	if next := p.peek(); next.kind != tokOp || next.text != ")" {
+		for {
+			arg, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if next := p.peek(); next.kind == tokOp && next.text == "," {
+				p.next()
+				continue
+			}
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(args) < fn.minArgs || fn.maxArgs >= 0 && len(args) > fn.maxArgs {
+		return nil, &derivedExprError{t.pos, fmt.Sprintf("wrong number of arguments to %s", t.text)}
+	}
+	return &derivedCall{fn: fn, args: args}, nil
+}
+
+// cutDerivedPrefix splits "Derived.Name"
+func cutDerivedPrefix(path string) (string, bool) {
+	if len(path) > len(derivedPacket)+1 && strings.EqualFold(path[:len(derivedPacket)+1], derivedPacket+".") {
+		return path[len(derivedPacket)+1:], true
+	}
+	return "", false
+}
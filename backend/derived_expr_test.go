@@ -0,0 +1,278 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// evalTestExpr parses and evaluates an expression with the given derived
+// channel values, Derived.X references are resolved as written
+func evalTestExpr(t *testing.T, src string, values map[string]float64) (float64, error) {
+	t.Helper()
+	node, _, refs, err := parseDerivedExpr(src)
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	for _, nodes := range refs {
+		for _, ref := range nodes {
+			for name := range values {
+				if strings.EqualFold(name, ref.name) {
+					ref.name = name
+				}
+			}
+		}
+	}
+	return node.eval(&derivedEnv{focusCar: -1, values: values})
+}
+
+func TestDerivedExprPrecedence(t *testing.T) {
+	values := map[string]float64{"X": 3, "Zero": 0}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		// Arithmetic
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"8 / 4 / 2", 1},
+		{"7 % 4 * 2", 6},
+		{"1.5e1 + .5", 15.5},
+		// Unary binds looser than ^, tighter than * and +
+		{"-Derived.X^2", -9},
+		{"(-Derived.X)^2", 9},
+		{"-2 * 3", -6},
+		{"- -2", 2},
+		{"+2", 2},
+		{"2 ^ -1", 0.5},
+		// ^ is right associative
+		{"2 ^ 3 ^ 2", 512},
+		{"(2 ^ 3) ^ 2", 64},
+		// Comparisons, then equality, then && and ||
+		{"1 + 1 == 2", 1},
+		{"1 < 2 == 2 < 1", 0},
+		{"3 > 2 > 1", 0},
+		{"1 || 0 && 0", 1},
+		{"(1 || 0) && 0", 0},
+		{"!0 && 1", 1},
+		{"!Derived.Zero + 1", 2},
+		// Ternary is right associative and binds loosest
+		{"1 ? 2 : 0 ? 3 : 4", 2},
+		{"0 ? 2 : 1 ? 3 : 4", 3},
+		{"0 ? 2 : 0 ? 3 : 4", 4},
+		{"1 ? 0 ? 5 : 6 : 7", 6},
+		{"Derived.X > 2 ? Derived.X * 2 : 0", 6},
+		{"1 + 1 ? 10 : 20", 10},
+		// Constants and functions
+		{"pi", math.Pi},
+		{"TRUE + false", 1},
+		{"max(1, Derived.X, 2) + min(4, 5)", 7},
+		{"clamp(5, 0, 3)", 3},
+		{"hypot(3, 4)", 5},
+		{"avg(1, 2, 6)", 3},
+		{"SIGN(-Derived.X)", -1},
+		{"pow(2, 10)", 1024},
+	}
+	for _, tt := range tests {
+		got, err := evalTestExpr(t, tt.src, values)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestDerivedExprShortCircuit(t *testing.T) {
+	// The skipped side reads a channel without a value, which is an error
+	for _, src := range []string{"0 && Derived.Missing", "1 || Derived.Missing", "1 ? 2 : Derived.Missing", "if(0, Derived.Missing, 2)"} {
+		if _, err := evalTestExpr(t, src, map[string]float64{}); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+	for _, src := range []string{"1 && Derived.Missing", "if(1, Derived.Missing, 2)", "max(1, Derived.Missing)"} {
+		if _, err := evalTestExpr(t, src, map[string]float64{}); err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+}
+
+func TestDerivedExprErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{"", 0, "empty expression"},
+		{"   ", 0, "empty expression"},
+		{"1 +", 3, "unexpected end of expression"},
+		{"(1 + 2", 6, `expected ")"`},
+		{"1 2", 2, `unexpected "2"`},
+		{"1 $ 2", 2, `unexpected '$'`},
+		{"1 + )", 4, `unexpected ")"`},
+		{"1 ? 2", 5, `expected ":"`},
+		{"1e+", 0, "invalid number"},
+		{"1 + Motion[0", 10, "missing ]"},
+		{"nope(1)", 0, "unknown function nope"},
+		{"2 * Derived.bad_name", 4, "invalid derived channel name"},
+		{"max(1,)", 6, "unexpected"},
+	}
+	for _, tt := range tests {
+		_, _, _, err := parseDerivedExpr(tt.src)
+		exprErr, ok := err.(*derivedExprError)
+		if !ok {
+			t.Errorf("%q: error %v, want a derivedExprError", tt.src, err)
+			continue
+		}
+		if exprErr.Pos != tt.pos || !strings.Contains(exprErr.Msg, tt.msg) {
+			t.Errorf("%q: error at %d %q, want at %d %q", tt.src, exprErr.Pos, exprErr.Msg, tt.pos, tt.msg)
+		}
+	}
+}
+
+func TestDerivedExprArity(t *testing.T) {
+	tests := []struct {
+		src string
+		ok  bool
+	}{
+		{"abs(1)", true},
+		{"abs()", false},
+		{"abs(1, 2)", false},
+		{"pow(2, 3)", true},
+		{"pow(2)", false},
+		{"atan2(1, 2, 3)", false},
+		{"clamp(1, 2, 3)", true},
+		{"clamp(1, 2)", false},
+		{"min(1)", true},
+		{"max()", false},
+		{"sum(1, 2, 3, 4, 5, 6)", true},
+		{"avg()", false},
+		{"if(1, 2, 3)", true},
+		{"if(1, 2)", false},
+	}
+	for _, tt := range tests {
+		_, _, _, err := parseDerivedExpr(tt.src)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+		}
+		if !tt.ok {
+			exprErr, isExprErr := err.(*derivedExprError)
+			if !isExprErr || exprErr.Pos != 0 || !strings.Contains(exprErr.Msg, "wrong number of arguments") {
+				t.Errorf("%s: error %v, want wrong number of arguments at 0", tt.src, err)
+			}
+		}
+	}
+}
+
+func TestCompileDerivedChannelsReferences(t *testing.T) {
+	saved := DerivedChannels
+	t.Cleanup(func() {
+		DerivedChannels = saved
+		derived.Lock()
+		compileDerivedChannels()
+		derived.Unlock()
+	})
+	DerivedChannels = []DerivedChannel{
+		{Name: "Total", Expression: "Derived.half * 2 + derived.Base", Enabled: true},
+		{Name: "Half", Expression: "Derived.Base / 2", Enabled: true},
+		{Name: "Base", Expression: "10", Enabled: true},
+		// A -> B -> A
+		{Name: "A", Expression: "Derived.B + 1", Enabled: true},
+		{Name: "B", Expression: "Derived.A + 1", Enabled: true},
+		{Name: "Self", Expression: "Derived.Self + 1", Enabled: true},
+		{Name: "UsesCycle", Expression: "Derived.A", Enabled: true},
+		{Name: "UsesDisabled", Expression: "Derived.Off", Enabled: true},
+		{Name: "Off", Expression: "1", Enabled: false},
+		{Name: "UsesUnknown", Expression: "Derived.Nope", Enabled: true},
+		// Chain1 -> Chain2 -> missing, Chain2 fails while Chain1 is visited
+		{Name: "Chain1", Expression: "Derived.Chain2 + 1", Enabled: true},
+		{Name: "Chain2", Expression: "Derived.Missing * 2", Enabled: true},
+		{Name: "Broken", Expression: "1 +", Enabled: true},
+	}
+	derived.Lock()
+	compileDerivedChannels()
+	programs, errors := derived.programs, derived.errors
+	derived.Unlock()
+
+	order := map[string]int{}
+	for i, p := range programs {
+		order[p.name] = i
+	}
+	for _, name := range []string{"Base", "Half", "Total"} {
+		if _, ok := order[name]; !ok {
+			t.Errorf("%s was not compiled: %s", name, errors[name])
+		}
+	}
+	if !(order["Base"] < order["Half"] && order["Half"] < order["Total"]) {
+		t.Errorf("evaluation order %v, want Base, Half, Total", order)
+	}
+	for _, name := range []string{"A", "B", "Self", "UsesCycle", "UsesDisabled", "UsesUnknown", "Chain1", "Chain2", "Broken"} {
+		if _, ok := order[name]; ok {
+			t.Errorf("%s was compiled", name)
+		}
+		if errors[name] == "" {
+			t.Errorf("%s has no error", name)
+		}
+	}
+	if !strings.Contains(errors["Self"], "reference cycle") {
+		t.Errorf("Self: %q, want a reference cycle", errors["Self"])
+	}
+	if !strings.Contains(errors["A"]+errors["B"], "reference cycle") {
+		t.Errorf("A: %q, B: %q, want a reference cycle", errors["A"], errors["B"])
+	}
+	for _, name := range []string{"Chain1", "Chain2"} {
+		if want := "Derived.Missing is not an enabled, valid channel"; errors[name] != want {
+			t.Errorf("%s: %q, want %q", name, errors[name], want)
+		}
+	}
+	if _, ok := errors["Off"]; ok {
+		t.Errorf("disabled channel has an error: %q", errors["Off"])
+	}
+
+	// References were given the configured spelling, so evaluation finds them
+	values := map[string]float64{}
+	env := &derivedEnv{focusCar: -1, values: values}
+	for _, p := range programs {
+		v, err := p.node.eval(env)
+		if err != nil {
+			t.Fatalf("%s: %v", p.name, err)
+		}
+		values[p.name] = v
+	}
+	if values["Total"] != 20 {
+		t.Errorf("Total = %v, want 20", values["Total"])
+	}
+}
+
+// Derived channels are often flags, going back to 0 is sent over OSC even
+// without allowZero, repeats are not
+func TestDerivedChannelFallingEdgeIsSent(t *testing.T) {
+	path := &oscPath{packet: derivedPacket, car: oscCarNone, field: "InPitLane", leaf: reflect.TypeOf(float64(0)), derived: "InPitLane"}
+	b := oscBinding{
+		destination: "test",
+		key:         "Derived.InPitLane",
+		entry:       OSCAddressEntry{Address: "/test/derived-flag", Enabled: true},
+		path:        path,
+	}
+	frame := &oscFrame{focusCar: -1}
+	lastKey := b.destination + b.entry.Address
+	for _, v := range []float64{1, 0, 0, 1} {
+		frame.sendMapping(b, v)
+		// Next packet after the broadcast interval
+		last := lastSentOSC[lastKey]
+		last.t = last.t.Add(-time.Hour)
+		lastSentOSC[lastKey] = last
+	}
+	var got []any
+	for _, msg := range frame.messages["test"] {
+		got = append(got, msg.Arguments...)
+	}
+	if want := []any{float32(1), float32(0), float32(1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
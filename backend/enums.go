@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// -------------------- F1 25 lookup tables --------------------
+// Display names for the ids in the spec appendix, keyed by id
+
+var TrackNames = map[int]string{
+	0:  "Melbourne",
+	1:  "Paul Ricard",
+	2:  "Shanghai",
+	3:  "Sakhir",
+	4:  "Catalunya",
+	5:  "Monaco",
+	6:  "Montreal",
+	7:  "Silverstone",
+	8:  "Hockenheim",
+	9:  "Hungaroring",
+	10: "Spa",
+	11: "Monza",
+	12: "Singapore",
+	13: "Suzuka",
+	14: "Abu Dhabi",
+	15: "Texas",
+	16: "Brazil",
+	17: "Austria",
+	18: "Sochi",
+	19: "Mexico",
+	20: "Baku",
+	21: "Sakhir Short",
+	22: "Silverstone Short",
+	23: "Texas Short",
+	24: "Suzuka Short",
+	25: "Hanoi",
+	26: "Zandvoort",
+	27: "Imola",
+	28: "Portimão",
+	29: "Jeddah",
+	30: "Miami",
+	31: "Las Vegas",
+	32: "Losail",
+	39: "Silverstone (Reverse)",
+	40: "Austria (Reverse)",
+	41: "Zandvoort (Reverse)",
+}
+
+var TeamNames = map[int]string{
+	0:   "Mercedes",
+	1:   "Ferrari",
+	2:   "Red Bull Racing",
+	3:   "Williams",
+	4:   "Aston Martin",
+	5:   "Alpine",
+	6:   "RB",
+	7:   "Haas",
+	8:   "McLaren",
+	9:   "Sauber",
+	41:  "F1 Generic",
+	104: "F1 Custom Team",
+	129: "Konnersport",
+	142: "APXGP '24",
+	154: "APXGP '25",
+	155: "Konnersport '24",
+	158: "Art GP '24",
+	159: "Campos '24",
+	160: "Rodin Motorsport '24",
+	161: "AIX Racing '24",
+	162: "DAMS '24",
+	163: "Hitech '24",
+	164: "MP Motorsport '24",
+	165: "Prema '24",
+	166: "Trident '24",
+	167: "Van Amersfoort Racing '24",
+	168: "Invicta '24",
+	185: "Mercedes '24",
+	186: "Ferrari '24",
+	187: "Red Bull Racing '24",
+	188: "Williams '24",
+	189: "Aston Martin '24",
+	190: "Alpine '24",
+	191: "RB '24",
+	192: "Haas '24",
+	193: "McLaren '24",
+	194: "Sauber '24",
+}
+
+var ActualTyreCompoundNames = map[int]string{
+	16: "C5",
+	17: "C4",
+	18: "C3",
+	19: "C2",
+	20: "C1",
+	21: "C0",
+	22: "C6",
+	7:  "Inter",
+	8:  "Wet",
+	9:  "Dry",
+	10: "Wet",
+	11: "Super Soft",
+	12: "Soft",
+	13: "Medium",
+	14: "Hard",
+	15: "Wet",
+}
+
+var VisualTyreCompoundNames = map[int]string{
+	16: "Soft",
+	17: "Medium",
+	18: "Hard",
+	7:  "Inter",
+	8:  "Wet",
+	15: "Wet",
+	19: "Super Soft",
+	20: "Soft",
+	21: "Medium",
+	22: "Hard",
+}
+
+var WeatherNames = map[int]string{
+	0: "Clear",
+	1: "Light cloud",
+	2: "Overcast",
+	3: "Light rain",
+	4: "Heavy rain",
+	5: "Storm",
+}
+
+var SafetyCarStatusNames = map[int]string{
+	0: "No Safety Car",
+	1: "Full Safety Car",
+	2: "Virtual Safety Car",
+	3: "Formation Lap",
+}
+
+var ResultStatusNames = map[int]string{
+	0: "Invalid",
+	1: "Inactive",
+	2: "Active",
+	3: "Finished",
+	4: "Did not finish",
+	5: "Disqualified",
+	6: "Not classified",
+	7: "Retired",
+}
+
+var SessionTypeNames = map[int]string{
+	0:  "Unknown",
+	1:  "Practice 1",
+	2:  "Practice 2",
+	3:  "Practice 3",
+	4:  "Short Practice",
+	5:  "Qualifying 1",
+	6:  "Qualifying 2",
+	7:  "Qualifying 3",
+	8:  "Short Qualifying",
+	9:  "One-Shot Qualifying",
+	10: "Sprint Shootout 1",
+	11: "Sprint Shootout 2",
+	12: "Sprint Shootout 3",
+	13: "Short Sprint Shootout",
+	14: "One-Shot Sprint Shootout",
+	15: "Race",
+	16: "Race 2",
+	17: "Race 3",
+	18: "Time Trial",
+}
+
+var PitStatusNames = map[int]string{
+	0: "None",
+	1: "Pitting",
+	2: "In pit area",
+}
+
+var DriverStatusNames = map[int]string{
+	0: "In garage",
+	1: "Flying lap",
+	2: "In lap",
+	3: "Out lap",
+	4: "On track",
+}
+
+var FormulaNames = map[int]string{
+	0: "F1 Modern",
+	1: "F1 Classic",
+	2: "F2",
+	3: "F1 Generic",
+	4: "Beta",
+	6: "Esports",
+	8: "F1 World",
+	9: "F1 Elimination",
+}
+
+// enumFields maps packet field names to their lookup table
+var enumFields = map[string]map[int]string{
+	"TrackId":            TrackNames,
+	"TeamId":             TeamNames,
+	"ActualTyreCompound": ActualTyreCompoundNames,
+	"VisualTyreCompound": VisualTyreCompoundNames,
+	"TyreStintsActual":   ActualTyreCompoundNames,
+	"TyreStintsVisual":   VisualTyreCompoundNames,
+	"Weather":            WeatherNames,
+	"SafetyCarStatus":    SafetyCarStatusNames,
+	"ResultStatus":       ResultStatusNames,
+	"SessionType":        SessionTypeNames,
+	"PitStatus":          PitStatusNames,
+	"DriverStatus":       DriverStatusNames,
+	"Formula":            FormulaNames,
+}
+
+// enumLabel returns the display name of an id field, "" if the field isn't
+// an enum or the id is unknown. Array indexes in field ("TyreStintsActual[2]")
+// are ignored.
+func enumLabel(field string, value any) string {
+	if i := strings.IndexByte(field, '['); i >= 0 {
+		field = field[:i]
+	}
+	names, ok := enumFields[field]
+	if !ok {
+		return ""
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return names[int(v.Int())]
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return names[int(v.Uint())]
+	}
+	return ""
+}
+
+// tyreLabel combines the visual and actual compound, e.g. "Soft C3"
+func tyreLabel(actual, visual uint8) string {
+	a, v := ActualTyreCompoundNames[int(actual)], VisualTyreCompoundNames[int(visual)]
+	if a == "" || a == v {
+		return v
+	}
+	if v == "" {
+		return a
+	}
+	return v + " " + a
+}
+
+// labelledValue converts a value for JSON output and adds a "<Field>Label"
+// next to every enum field ("<Field>Labels" for enum arrays). Structs become
+// maps keyed by their JSON names.
+func labelledValue(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		out := map[string]any{}
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := field.Name
+			if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			fv := v.Field(i)
+			if _, isEnum := enumFields[field.Name]; isEnum {
+				if fv.Kind() == reflect.Array || fv.Kind() == reflect.Slice {
+					labels := make([]string, fv.Len())
+					for j := range labels {
+						labels[j] = enumLabel(field.Name, fv.Index(j).Interface())
+					}
+					out[name+"Labels"] = labels
+				} else if label := enumLabel(field.Name, fv.Interface()); label != "" {
+					out[name+"Label"] = label
+				}
+			}
+			out[name] = labelledValue(fv)
+		}
+		return out
+	case reflect.Array, reflect.Slice:
+		elem := v.Type().Elem().Kind()
+		if elem != reflect.Struct && elem != reflect.Interface && elem != reflect.Ptr && elem != reflect.Map {
+			return v.Interface() // scalar arrays stay as they are
+		}
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = labelledValue(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = labelledValue(iter.Value())
+		}
+		return out
+	}
+	return v.Interface()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// -------------------- F1 25 Event details --------------------
+// Typed versions of the PacketEventData.EventDetails union, one per event code
+
+type FastestLapEvent struct {
+	VehicleIdx uint8
+	LapTime    float32 // seconds
+}
+
+type RetirementEvent struct {
+	VehicleIdx uint8
+	Reason     uint8
+}
+
+type DRSDisabledEvent struct {
+	Reason uint8
+}
+
+type TeamMateInPitsEvent struct {
+	VehicleIdx uint8
+}
+
+type RaceWinnerEvent struct {
+	VehicleIdx uint8
+}
+
+type PenaltyEvent struct {
+	PenaltyType      uint8
+	InfringementType uint8
+	VehicleIdx       uint8
+	OtherVehicleIdx  uint8
+	Time             uint8
+	LapNum           uint8
+	PlacesGained     uint8
+}
+
+type SpeedTrapEvent struct {
+	VehicleIdx                 uint8
+	Speed                      float32
+	IsOverallFastestInSession  uint8
+	IsDriverFastestInSession   uint8
+	FastestVehicleIdxInSession uint8
+	FastestSpeedInSession      float32
+}
+
+type StartLightsEvent struct {
+	NumLights uint8
+}
+
+type DriveThroughPenaltyServedEvent struct {
+	VehicleIdx uint8
+}
+
+type StopGoPenaltyServedEvent struct {
+	VehicleIdx uint8
+	StopTime   float32
+}
+
+type FlashbackEvent struct {
+	FlashbackFrameIdentifier uint32
+	FlashbackSessionTime     float32
+}
+
+type ButtonsEvent struct {
+	ButtonStatus uint32
+}
+
+type OvertakeEvent struct {
+	OvertakingVehicleIdx     uint8
+	BeingOvertakenVehicleIdx uint8
+}
+
+type SafetyCarEvent struct {
+	SafetyCarType uint8 // 0 = No Safety Car, 1 = Full, 2 = Virtual, 3 = Formation Lap
+	EventType     uint8 // 0 = Deployed, 1 = Returning, 2 = Returned, 3 = Resume Race
+}
+
+type CollisionEvent struct {
+	Vehicle1Idx uint8
+	Vehicle2Idx uint8
+}
+
+// EventTypes maps event string codes to the event name used downstream
+// (WebSocket "Event/<name>", OSC mapping "Event_<name>") and a constructor
+// for the details struct, nil for events without details
+var EventTypes = map[string]struct {
+	Name    string
+	Details func() any
+}{
+	"SSTA": {"session_started", nil},
+	"SEND": {"session_ended", nil},
+	"FTLP": {"fastest_lap", func() any { return &FastestLapEvent{} }},
+	"RTMT": {"retirement", func() any { return &RetirementEvent{} }},
+	"DRSE": {"drs_enabled", nil},
+	"DRSD": {"drs_disabled", func() any { return &DRSDisabledEvent{} }},
+	"TMPT": {"team_mate_in_pits", func() any { return &TeamMateInPitsEvent{} }},
+	"CHQF": {"chequered_flag", nil},
+	"RCWN": {"race_winner", func() any { return &RaceWinnerEvent{} }},
+	"PENA": {"penalty", func() any { return &PenaltyEvent{} }},
+	"SPTP": {"speed_trap", func() any { return &SpeedTrapEvent{} }},
+	"STLG": {"start_lights", func() any { return &StartLightsEvent{} }},
+	"LGOT": {"lights_out", nil},
+	"DTSV": {"drive_through_served", func() any { return &DriveThroughPenaltyServedEvent{} }},
+	"SGSV": {"stop_go_served", func() any { return &StopGoPenaltyServedEvent{} }},
+	"FLBK": {"flashback", func() any { return &FlashbackEvent{} }},
+	"BUTN": {"buttons", func() any { return &ButtonsEvent{} }},
+	"RDFL": {"red_flag", nil},
+	"OVTK": {"overtake", func() any { return &OvertakeEvent{} }},
+	"SCAR": {"safety_car", func() any { return &SafetyCarEvent{} }},
+	"COLL": {"collision", func() any { return &CollisionEvent{} }},
+}
+
+// decodeEventDetails returns the event name and typed details (nil for
+// events without details) for an Event packet
+func decodeEventDetails(pkt PacketEventData) (string, any, error) {
+	code := string(pkt.EventStringCode[:])
+	eventType, ok := EventTypes[code]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown event code %q", code)
+	}
+	if eventType.Details == nil {
+		return eventType.Name, nil, nil
+	}
+	details := eventType.Details()
+	if err := binary.Read(bytes.NewReader(pkt.EventDetails[:]), binary.LittleEndian, details); err != nil {
+		return eventType.Name, nil, err
+	}
+	return eventType.Name, reflect.ValueOf(details).Elem().Interface(), nil
+}
+
+func handleEventPacket(header PacketHeader, data []byte) {
+	pkt, err := decodeEventPacket(data)
+	if err != nil {
+		return
+	}
+	name, details, err := decodeEventDetails(pkt)
+	if err != nil {
+		log.Printf("[error] decode Event details: %v", err)
+		return
+	}
+	// The rewind rolls back first, so it doesn't drop the flashback event itself
+	if flashback, ok := details.(FlashbackEvent); ok {
+		handleFlashbackEvent(header, flashback)
+	}
+	publishEvent(header, name, details)
+	if name == "session_ended" {
+		completeSession(header, "session_ended")
+	}
+}
+
+// publishEvent sends a discrete event to WebSocket clients, OSC
+// destinations and webhooks. Events are never throttled or deduplicated.
+func publishEvent(header PacketHeader, name string, details any) {
+	if Config.DebugOutput {
+		log.Printf("[debug] Event %s: %+v", name, details)
+	}
+	storeEventState(header, name, details)
+	var value any = struct{}{}
+	if details != nil {
+		value = details
+	}
+	publishWS(header, "Event/"+name, -1, value)
+
+	var args []interface{}
+	if details != nil {
+		v := reflect.ValueOf(details)
+		if v.Kind() == reflect.Struct {
+			for i := 0; i < v.NumField(); i++ {
+				if v.Field(i).Kind() == reflect.Slice {
+					continue // lists have no OSC argument type
+				}
+				args = append(args, v.Field(i).Interface())
+			}
+		}
+	}
+	frame := newOSCFrame(header, "Event")
+	frame.sendEvent("Event_"+name, args...)
+	frame.flush()
+
+	dispatchWebhooks(header, name, details)
+}
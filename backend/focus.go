@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Focus car modes
+const (
+	FocusPlayer    = "player"    // m_playerCarIndex from the packet header
+	FocusSpectator = "spectator" // Session.SpectatorCarIndex while spectating
+	FocusIndex     = "index"     // a fixed car index
+	FocusDriver    = "driver"    // a driver name from the Participants packet
+)
+
+// FocusCarConfig selects the car that all single-car outputs (OSC mappings,
+// the CarTelemetry/summary line) follow
+type FocusCarConfig struct {
+	Mode   string `json:"mode"`
+	Index  int    `json:"index"`
+	Driver string `json:"driver"`
+}
+
+// Latest spectator state and driver names, used to resolve the focus car
+var focusState struct {
+	sync.Mutex
+	isSpectating bool
+	spectatorCar uint8
+	lastPlayer   uint8
+	names        [22]string
+}
+
+func updateFocusFromSession(pkt PacketSessionData) {
+	focusState.Lock()
+	defer focusState.Unlock()
+	focusState.isSpectating = pkt.IsSpectating == 1
+	focusState.spectatorCar = pkt.SpectatorCarIndex
+}
+
+func updateFocusFromParticipants(pkt PacketParticipantsData) {
+	focusState.Lock()
+	defer focusState.Unlock()
+	for i, p := range pkt.Participants {
+		focusState.names[i] = p.Name.String()
+	}
+}
+
+// participantName is the latest Participants name of a car
+func participantName(car int) string {
+	focusState.Lock()
+	defer focusState.Unlock()
+	if car < 0 || car >= len(focusState.names) {
+		return ""
+	}
+	return focusState.names[car]
+}
+
+// focusCarIndex resolves the configured focus car for a packet. When the
+// configured car can't be resolved it falls back to the player car, and to
+// the spectated car if there is no player car (255 while spectating).
+func focusCarIndex(header PacketHeader) int {
+	focusState.Lock()
+	defer focusState.Unlock()
+	focusState.lastPlayer = header.PlayerCarIndex
+	return resolveFocusCar(header.PlayerCarIndex)
+}
+
+// resolveFocusCar expects focusState to be locked
+func resolveFocusCar(player uint8) int {
+	cfg := Config.FocusCar
+	switch cfg.Mode {
+	case FocusSpectator:
+		if focusState.isSpectating && focusState.spectatorCar < 22 {
+			return int(focusState.spectatorCar)
+		}
+	case FocusIndex:
+		if cfg.Index >= 0 && cfg.Index < 22 {
+			return cfg.Index
+		}
+	case FocusDriver:
+		for i, name := range focusState.names {
+			if name != "" && strings.EqualFold(name, cfg.Driver) {
+				return i
+			}
+		}
+	}
+	if player < 22 {
+		return int(player)
+	}
+	if focusState.isSpectating && focusState.spectatorCar < 22 {
+		return int(focusState.spectatorCar)
+	}
+	return -1
+}
+
+// cString converts a fixed-size, null-terminated UTF-8 byte array to a string
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// REST API for the currently resolved focus car. The selector itself is part
+// of /api/config ("focus_car").
+func handleFocusCarAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] FocusCar API handler crashed: %v", r)
+		}
+	}()
+
+	focusState.Lock()
+	idx := resolveFocusCar(focusState.lastPlayer)
+	name := ""
+	if idx >= 0 {
+		name = focusState.names[idx]
+	}
+	focusState.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"config":   Config.FocusCar,
+		"carIndex": idx,
+		"name":     name,
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// ForwardTarget is a downstream UDP receiver (SimHub, motion rig, logger...)
+// that gets an unchanged copy of every datagram allowed by PacketForwardingConfig
+type ForwardTarget struct {
+	Name    string `json:"name"`
+	Addr    string `json:"addr"`
+	Port    int    `json:"port"`
+	Enabled bool   `json:"enabled"`
+}
+
+// ForwardTargets is guarded by forwardersMu once the service runs
+var ForwardTargets = []ForwardTarget{}
+var forwardTargetsConfigPath string
+
+type forwarder struct {
+	index   int // of the target in ForwardTargets, targets may be identical
+	conn    *net.UDPConn
+	packets atomic.Uint64
+	errors  atomic.Uint64
+	lastErr atomic.Value // string
+}
+
+var forwardersMu sync.RWMutex
+var forwarders []*forwarder
+
+func InitForwardTargetsConfig() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	appDir := filepath.Join(configDir, "f1-telem-bridge")
+	os.MkdirAll(appDir, 0755)
+	forwardTargetsConfigPath = filepath.Join(appDir, "forward_targets.json")
+
+	if _, err := os.Stat(forwardTargetsConfigPath); os.IsNotExist(err) {
+		SaveForwardTargetsConfig()
+	} else {
+		LoadForwardTargetsConfig()
+	}
+}
+
+func SaveForwardTargetsConfig() {
+	f, err := os.Create(forwardTargetsConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not create forward targets config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(ForwardTargets); err != nil {
+		log.Printf("[error] Could not encode forward targets config: %v", err)
+	}
+}
+
+func LoadForwardTargetsConfig() {
+	f, err := os.Open(forwardTargetsConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not open forward targets config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&ForwardTargets); err != nil {
+		log.Printf("[error] Could not decode forward targets config: %v", err)
+	}
+}
+
+// restartForwarders closes all forwarding sockets and dials the enabled targets again
+func restartForwarders() {
+	forwardersMu.Lock()
+	defer forwardersMu.Unlock()
+	dialForwarders()
+}
+
+// dialForwarders expects forwardersMu to be locked
+func dialForwarders() {
+	for _, f := range forwarders {
+		f.conn.Close()
+	}
+	forwarders = nil
+
+	for i, t := range ForwardTargets {
+		if !t.Enabled {
+			continue
+		}
+		// Never forward back into our own listener, that would loop forever
+		if t.Port == Config.UDPPort && (t.Addr == Config.UDPAddr || (isLoopback(t.Addr) && isLoopback(Config.UDPAddr))) {
+			log.Printf("[warn] Forward target %q points at the UDP listener itself, skipping", t.Name)
+			continue
+		}
+		raddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", t.Addr, t.Port))
+		if err != nil {
+			log.Printf("[error] Forward target %q: %v", t.Name, err)
+			continue
+		}
+		conn, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			log.Printf("[error] Forward target %q: %v", t.Name, err)
+			continue
+		}
+		forwarders = append(forwarders, &forwarder{index: i, conn: conn})
+	}
+	log.Printf("[service] Forwarding raw UDP to %d target(s)", len(forwarders))
+}
+
+func isLoopback(addr string) bool {
+	ip := net.ParseIP(addr)
+	return addr == "localhost" || (ip != nil && (ip.IsLoopback() || ip.IsUnspecified()))
+}
+
+// forwardDatagram re-sends the raw datagram to every active target. The packet
+// ID filter is the same one handleUDPPacket uses for decoding.
+func forwardDatagram(data []byte) {
+	if len(data) < 7 || !PacketForwardingConfig[data[6]] {
+		return
+	}
+	forwardersMu.RLock()
+	defer forwardersMu.RUnlock()
+	for _, f := range forwarders {
+		if _, err := f.conn.Write(data); err != nil {
+			f.errors.Add(1)
+			f.lastErr.Store(err.Error())
+			continue
+		}
+		f.packets.Add(1)
+	}
+}
+
+type forwardTargetStatus struct {
+	ForwardTarget
+	Active    bool   `json:"active"`
+	Packets   uint64 `json:"packets"`
+	Errors    uint64 `json:"errors"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+func forwardTargetStatuses() []forwardTargetStatus {
+	forwardersMu.RLock()
+	defer forwardersMu.RUnlock()
+	statuses := make([]forwardTargetStatus, 0, len(ForwardTargets))
+	for i, t := range ForwardTargets {
+		status := forwardTargetStatus{ForwardTarget: t}
+		for _, f := range forwarders {
+			if f.index == i {
+				status.Active = true
+				status.Packets = f.packets.Load()
+				status.Errors = f.errors.Load()
+				if e, ok := f.lastErr.Load().(string); ok {
+					status.LastError = e
+				}
+				break
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// REST API for listing/replacing raw forward targets and their counters
+func handleForwardTargetsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] ForwardTargets API handler crashed: %v", r)
+		}
+	}()
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(forwardTargetStatuses())
+	} else if r.Method == http.MethodPost {
+		var targets []ForwardTarget
+		if err := json.NewDecoder(r.Body).Decode(&targets); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, t := range targets {
+			if t.Addr == "" || t.Port <= 0 || t.Port > 65535 {
+				http.Error(w, fmt.Sprintf("invalid forward target %q: %s:%d", t.Name, t.Addr, t.Port), http.StatusBadRequest)
+				return
+			}
+		}
+		// Replaced together with the forwarders so statuses never mix old and new
+		forwardersMu.Lock()
+		ForwardTargets = targets
+		SaveForwardTargetsConfig()
+		dialForwarders()
+		forwardersMu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}
+}
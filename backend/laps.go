@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Lap history of the current session. A lap is finalised when the car's
+// CurrentLapNum increments; LapData carries the lap time and the first two
+// sectors, sector 3 is the remainder. Only valid laps count for bests.
+// Overall bests are per track and kept in lap_records.json.
+
+type LapRecord struct {
+	CarIndex           int       `json:"carIndex"`
+	LapNum             uint8     `json:"lapNum"`
+	LapTimeMS          uint32    `json:"lapTimeMS"`
+	SectorMS           [3]uint32 `json:"sectorMS"` // 0 when not seen
+	Valid              bool      `json:"valid"`
+	Pit                bool      `json:"pit"`      // in the pit lane during the lap
+	TopSpeed           uint16    `json:"topSpeed"` // km/h
+	StartTime          float32   `json:"startTime"`
+	EndTime            float32   `json:"endTime"` // session time
+	PersonalBest       bool      `json:"personalBest"`
+	SessionBest        bool      `json:"sessionBest"`
+	OverallBest        bool      `json:"overallBest"`
+	SectorPersonalBest [3]bool   `json:"sectorPersonalBest"`
+	SectorSessionBest  [3]bool   `json:"sectorSessionBest"`
+	SectorOverallBest  [3]bool   `json:"sectorOverallBest"`
+}
+
+// LapBest is the holder of a best lap or sector time
+type LapBest struct {
+	TimeMS     uint32 `json:"timeMS"`
+	CarIndex   int    `json:"carIndex"`
+	Name       string `json:"name,omitempty"`
+	LapNum     uint8  `json:"lapNum"`
+	SessionUID string `json:"sessionUid"`
+}
+
+type LapBests struct {
+	Lap     *LapBest    `json:"lap"`
+	Sectors [3]*LapBest `json:"sectors"`
+}
+
+// LapCompletedEvent is published as Event/lap_completed
+type LapCompletedEvent struct {
+	VehicleIdx   uint8
+	LapNum       uint8
+	LapTimeMS    uint32
+	Sector1MS    uint32
+	Sector2MS    uint32
+	Sector3MS    uint32
+	Valid        bool
+	Pit          bool
+	TopSpeed     uint16
+	PersonalBest bool
+	SessionBest  bool
+	OverallBest  bool
+}
+
+// Lap in progress, rebuilt from the next LapData after a rewind
+type currentLap struct {
+	lapNum    uint8
+	sectorMS  [2]uint32
+	invalid   bool
+	pit       bool
+	topSpeed  uint16
+	startTime float32
+}
+
+type carLaps struct {
+	laps    []*LapRecord
+	bests   LapBests
+	current *currentLap
+}
+
+var laps struct {
+	sync.Mutex
+	sessionUID uint64
+	trackId    int8
+	hasTrack   bool
+	cars       [22]carLaps
+	bests      LapBests
+}
+
+// Overall bests by track id, without the current session
+var lapRecords map[int8]*LapBests
+var lapRecordsPath string
+
+func InitLapRecords() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	appDir := filepath.Join(configDir, "f1-telem-bridge")
+	os.MkdirAll(appDir, 0755)
+	lapRecordsPath = filepath.Join(appDir, "lap_records.json")
+
+	lapRecords = map[int8]*LapBests{}
+	if _, err := os.Stat(lapRecordsPath); err == nil {
+		LoadLapRecords()
+	}
+}
+
+// SaveLapRecords expects laps to be locked
+func SaveLapRecords() {
+	f, err := os.Create(lapRecordsPath)
+	if err != nil {
+		log.Printf("[error] Could not create lap records file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(lapRecords); err != nil {
+		log.Printf("[error] Could not encode lap records: %v", err)
+	}
+}
+
+func LoadLapRecords() {
+	f, err := os.Open(lapRecordsPath)
+	if err != nil {
+		log.Printf("[error] Could not open lap records file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&lapRecords); err != nil {
+		log.Printf("[error] Could not decode lap records: %v", err)
+	}
+}
+
+// commitLapRecords merges the session bests into the overall records,
+// expects laps to be locked
+func commitLapRecords() {
+	if !laps.hasTrack || (laps.bests.Lap == nil && laps.bests.Sectors == [3]*LapBest{}) {
+		return
+	}
+	records := lapRecords[laps.trackId]
+	if records == nil {
+		records = &LapBests{}
+		lapRecords[laps.trackId] = records
+	}
+	improveBest(&records.Lap, laps.bests.Lap)
+	for i := range records.Sectors {
+		improveBest(&records.Sectors[i], laps.bests.Sectors[i])
+	}
+	SaveLapRecords()
+}
+
+// improveBest replaces *best with candidate if it is faster
+func improveBest(best **LapBest, candidate *LapBest) bool {
+	if candidate == nil || candidate.TimeMS == 0 {
+		return false
+	}
+	if *best == nil || candidate.TimeMS < (*best).TimeMS {
+		copied := *candidate
+		*best = &copied
+		return true
+	}
+	return false
+}
+
+// resetLaps starts the lap history of a new session, expects laps to be locked
+func resetLaps(sessionUID uint64) {
+	commitLapRecords()
+	laps.sessionUID = sessionUID
+	laps.hasTrack = false
+	laps.cars = [22]carLaps{}
+	laps.bests = LapBests{}
+}
+
+func updateLapsFromSession(pkt PacketSessionData) {
+	laps.Lock()
+	defer laps.Unlock()
+	if pkt.Header.SessionUID != laps.sessionUID {
+		resetLaps(pkt.Header.SessionUID)
+	}
+	laps.trackId = pkt.TrackId
+	laps.hasTrack = true
+}
+
+func updateLapsFromTelemetry(pkt PacketCarTelemetryData) {
+	laps.Lock()
+	defer laps.Unlock()
+	if pkt.Header.SessionUID != laps.sessionUID {
+		return
+	}
+	for i, t := range pkt.CarTelemetryData {
+		if c := laps.cars[i].current; c != nil && t.Speed > c.topSpeed {
+			c.topSpeed = t.Speed
+		}
+	}
+}
+
+func updateLapsFromLapData(pkt LapDataPacket) {
+	laps.Lock()
+	if pkt.Header.SessionUID != laps.sessionUID {
+		resetLaps(pkt.Header.SessionUID)
+	}
+	var completed []LapCompletedEvent
+	for i, d := range pkt.LapData {
+		if d.CurrentLapNum == 0 || d.ResultStatus < 2 {
+			continue // unused slot or inactive car
+		}
+		car := &laps.cars[i]
+		c := car.current
+		if c != nil && d.CurrentLapNum > c.lapNum {
+			if lap := finishLap(i, c, d, pkt.Header); lap != nil {
+				completed = append(completed, lapCompletedEvent(lap))
+			}
+			c = nil
+		}
+		if c == nil || d.CurrentLapNum != c.lapNum {
+			c = &currentLap{
+				lapNum:    d.CurrentLapNum,
+				startTime: pkt.Header.SessionTime - float32(d.CurrentLapTimeInMS)/1000,
+			}
+			car.current = c
+		}
+		if d.Sector >= 1 {
+			c.sectorMS[0] = uint32(d.Sector1TimeMinutesPart)*60000 + uint32(d.Sector1TimeMSPart)
+		}
+		if d.Sector >= 2 {
+			c.sectorMS[1] = uint32(d.Sector2TimeMinutesPart)*60000 + uint32(d.Sector2TimeMSPart)
+		}
+		c.invalid = c.invalid || d.CurrentLapInvalid == 1
+		c.pit = c.pit || d.PitStatus != 0
+	}
+	laps.Unlock()
+
+	// Published without the lock, event handlers may look at laps
+	for _, event := range completed {
+		publishEvent(pkt.Header, "lap_completed", event)
+	}
+}
+
+// finishLap records the lap c of a car from the first LapData of the next
+// lap, expects laps to be locked
+func finishLap(carIndex int, c *currentLap, next LapData, header PacketHeader) *LapRecord {
+	if next.LastLapTimeInMS == 0 {
+		return nil // lap not timed, e.g. the out lap in qualifying
+	}
+	lap := &LapRecord{
+		CarIndex:  carIndex,
+		LapNum:    c.lapNum,
+		LapTimeMS: next.LastLapTimeInMS,
+		Valid:     !c.invalid,
+		Pit:       c.pit,
+		TopSpeed:  c.topSpeed,
+		StartTime: c.startTime,
+		EndTime:   header.SessionTime - float32(next.CurrentLapTimeInMS)/1000,
+	}
+	lap.SectorMS[0], lap.SectorMS[1] = c.sectorMS[0], c.sectorMS[1]
+	if s1, s2 := c.sectorMS[0], c.sectorMS[1]; s1 > 0 && s2 > 0 && lap.LapTimeMS > s1+s2 {
+		lap.SectorMS[2] = lap.LapTimeMS - s1 - s2
+	}
+
+	car := &laps.cars[carIndex]
+	// A lap driven again after a flashback replaces the old one
+	for len(car.laps) > 0 && car.laps[len(car.laps)-1].LapNum >= lap.LapNum {
+		car.laps = car.laps[:len(car.laps)-1]
+	}
+	car.laps = append(car.laps, lap)
+	if lap.Valid {
+		markBests(lap)
+	}
+	return lap
+}
+
+// markBests flags the lap's personal, session and overall bests and updates
+// the bests, expects laps to be locked
+func markBests(lap *LapRecord) {
+	car := &laps.cars[lap.CarIndex]
+	var records *LapBests
+	if laps.hasTrack {
+		records = lapRecords[laps.trackId]
+	}
+	best := func(timeMS uint32) *LapBest {
+		return &LapBest{
+			TimeMS:     timeMS,
+			CarIndex:   lap.CarIndex,
+			Name:       participantName(lap.CarIndex),
+			LapNum:     lap.LapNum,
+			SessionUID: fmt.Sprint(laps.sessionUID),
+		}
+	}
+
+	candidate := best(lap.LapTimeMS)
+	lap.PersonalBest = improveBest(&car.bests.Lap, candidate)
+	lap.SessionBest = improveBest(&laps.bests.Lap, candidate)
+	lap.OverallBest = lap.SessionBest && (records == nil || records.Lap == nil || lap.LapTimeMS < records.Lap.TimeMS)
+	for i, timeMS := range lap.SectorMS {
+		if timeMS == 0 {
+			continue
+		}
+		candidate := best(timeMS)
+		lap.SectorPersonalBest[i] = improveBest(&car.bests.Sectors[i], candidate)
+		lap.SectorSessionBest[i] = improveBest(&laps.bests.Sectors[i], candidate)
+		lap.SectorOverallBest[i] = lap.SectorSessionBest[i] && (records == nil || records.Sectors[i] == nil || timeMS < records.Sectors[i].TimeMS)
+	}
+}
+
+func lapCompletedEvent(lap *LapRecord) LapCompletedEvent {
+	return LapCompletedEvent{
+		VehicleIdx:   uint8(lap.CarIndex),
+		LapNum:       lap.LapNum,
+		LapTimeMS:    lap.LapTimeMS,
+		Sector1MS:    lap.SectorMS[0],
+		Sector2MS:    lap.SectorMS[1],
+		Sector3MS:    lap.SectorMS[2],
+		Valid:        lap.Valid,
+		Pit:          lap.Pit,
+		TopSpeed:     lap.TopSpeed,
+		PersonalBest: lap.PersonalBest,
+		SessionBest:  lap.SessionBest,
+		OverallBest:  lap.OverallBest,
+	}
+}
+
+// rollbackLaps drops laps finished after a rewind point and rebuilds the
+// bests from the remaining laps. Laps in progress restart from the next
+// LapData, keeping their top speed.
+func rollbackLaps(header PacketHeader, toSessionTime float32) {
+	laps.Lock()
+	defer laps.Unlock()
+	if header.SessionUID != laps.sessionUID {
+		return
+	}
+	laps.bests = LapBests{}
+	for i := range laps.cars {
+		car := &laps.cars[i]
+		for len(car.laps) > 0 && car.laps[len(car.laps)-1].EndTime > toSessionTime {
+			car.laps = car.laps[:len(car.laps)-1]
+		}
+		if c := car.current; c != nil {
+			car.current = &currentLap{lapNum: c.lapNum, topSpeed: c.topSpeed, startTime: c.startTime}
+		}
+	}
+	for i := range laps.cars {
+		laps.cars[i].bests = LapBests{}
+	}
+	// Replay the remaining laps in session order
+	var remaining []*LapRecord
+	for i := range laps.cars {
+		remaining = append(remaining, laps.cars[i].laps...)
+	}
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i].EndTime < remaining[j].EndTime })
+	for _, lap := range remaining {
+		if lap.Valid {
+			markBests(lap)
+		}
+	}
+}
+
+type lapsCarResponse struct {
+	CarIndex int          `json:"carIndex"`
+	Name     string       `json:"name"`
+	Bests    LapBests     `json:"bests"`
+	Laps     []*LapRecord `json:"laps"`
+}
+
+type lapsResponse struct {
+	SessionUID string            `json:"sessionUid"`
+	TrackId    *int8             `json:"trackId"`
+	Bests      LapBests          `json:"bests"`   // this session
+	Overall    LapBests          `json:"overall"` // this track, including this session
+	Cars       []lapsCarResponse `json:"cars"`
+}
+
+// carLapsResponse expects laps to be locked
+func carLapsResponse(i int) lapsCarResponse {
+	car := &laps.cars[i]
+	list := car.laps
+	if list == nil {
+		list = []*LapRecord{}
+	}
+	return lapsCarResponse{CarIndex: i, Name: participantName(i), Bests: car.bests, Laps: list}
+}
+
+// REST API for the lap history of the current session:
+//
+//	GET /api/laps        bests and the laps of every car with laps
+//	GET /api/laps/{car}  one car by index
+func handleLapsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] Laps API handler crashed: %v", r)
+		}
+	}()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sub := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/laps"), "/")
+	laps.Lock()
+	defer laps.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if sub != "" {
+		car, err := strconv.Atoi(sub)
+		if err != nil || car < 0 || car >= len(laps.cars) {
+			http.Error(w, "invalid car index", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(carLapsResponse(car))
+		return
+	}
+
+	resp := lapsResponse{SessionUID: fmt.Sprint(laps.sessionUID), Bests: laps.bests, Cars: []lapsCarResponse{}}
+	if laps.hasTrack {
+		trackId := laps.trackId
+		resp.TrackId = &trackId
+		if records := lapRecords[trackId]; records != nil {
+			resp.Overall = *records
+		}
+	}
+	improveBest(&resp.Overall.Lap, laps.bests.Lap)
+	for i := range resp.Overall.Sectors {
+		improveBest(&resp.Overall.Sectors[i], laps.bests.Sectors[i])
+	}
+	for i := range laps.cars {
+		if len(laps.cars[i].laps) > 0 {
+			resp.Cars = append(resp.Cars, carLapsResponse(i))
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
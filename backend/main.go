@@ -157,6 +157,7 @@ func restartUDPListener() {
 		udpListenerConn = nil
 	}
 	udpListenerStop = make(chan struct{})
+	restartForwarders()
 	go func(stopCh chan struct{}) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -189,7 +190,9 @@ func restartUDPListener() {
 					log.Println("UDP read error:", err)
 					continue
 				}
-				handleUDPPacket(buf[:n])
+				recordDatagram(buf[:n])
+				forwardDatagram(buf[:n])
+				handleUDPPacket(buf[:n], sourceLive)
 			}
 		}
 	}(udpListenerStop)
@@ -208,12 +211,24 @@ func setCORS(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		runSimulate(os.Args[2:])
+		return
+	}
 	setupLogging()
 	log.Printf("F1 Telemetry Bridge version: %s", Version)
 	InitConfig()
 	InitTelemetryFieldsConfig()
 	InitPacketForwardingConfig()
 	InitOSCAddressesConfig()
+	InitDerivedChannelsConfig()
+	InitAlertRulesConfig()
+	InitOSCDestinationsConfig()
+	InitWebhooksConfig()
+	logOSCMappingProblems()
+	InitForwardTargetsConfig()
+	InitSessionHistory()
+	InitLapRecords()
 
 	distFS, _ := fs.Sub(content, "dist")
 
@@ -222,11 +237,43 @@ func main() {
 	http.HandleFunc("/api/config", handleConfigAPI)
 	http.HandleFunc("/api/fields", handleTelemetryFieldsAPI)
 	http.HandleFunc("/api/packet-forwarding", handlePacketForwardingAPI)
-	http.HandleFunc("/api/osc-addresses", handleOSCAddressesAPI)
+	http.HandleFunc("/api/osc-destinations", handleOSCDestinationsAPI)
+	http.HandleFunc("/api/osc-destinations/", handleOSCDestinationsAPI)
+	http.HandleFunc("/api/forward-targets", handleForwardTargetsAPI)
+	http.HandleFunc("/api/focus-car", handleFocusCarAPI)
+	http.HandleFunc("/api/state", handleStateAPI)
+	http.HandleFunc("/api/state/", handleStateAPI)
+	http.HandleFunc("/api/sessions", handleSessionsAPI)
+	http.HandleFunc("/api/sessions/", handleSessionsAPI)
+	http.HandleFunc("/api/laps", handleLapsAPI)
+	http.HandleFunc("/api/laps/", handleLapsAPI)
+	http.HandleFunc("/api/timing-tower", handleTimingTowerAPI)
+	http.HandleFunc("/api/recordings", handleRecordingsAPI)
+	http.HandleFunc("/api/recordings/", handleRecordingsAPI)
+	http.HandleFunc("/api/replay", handleReplayAPI)
+	http.HandleFunc("/api/replay/", handleReplayAPI)
 	// Service restart endpoints
 	http.HandleFunc("/api/restart/osc", handleRestartOSC)
 	http.HandleFunc("/api/restart/udp", handleRestartUDP)
 	http.HandleFunc("/api/restart/all", handleRestartAll)
+	// OSC sender counters
+	http.HandleFunc("/api/osc/stats", handleOSCStatsAPI)
+	// OSC mapping validation report
+	http.HandleFunc("/api/osc/mappings", handleOSCMappingsAPI)
+	// OSC mapping preview against the live value
+	http.HandleFunc("/api/osc/preview", handleOSCPreviewAPI)
+	// Derived channel definitions
+	http.HandleFunc("/api/derived-channels", handleDerivedChannelsAPI)
+	http.HandleFunc("/api/derived-channels/", handleDerivedChannelsAPI)
+	// Alert log and rules
+	http.HandleFunc("/api/alerts", handleAlertsAPI)
+	http.HandleFunc("/api/alert-rules", handleAlertRulesAPI)
+	http.HandleFunc("/api/alert-rules/", handleAlertRulesAPI)
+	// Webhooks and their delivery log
+	http.HandleFunc("/api/webhooks", handleWebhooksAPI)
+	http.HandleFunc("/api/webhooks/", handleWebhooksAPI)
+	// WebSocket client counters
+	http.HandleFunc("/api/ws-clients", handleWSClientsAPI)
 	// Version endpoint
 	http.HandleFunc("/api/version", handleVersionAPI)
 
@@ -236,6 +283,8 @@ func main() {
 	// Start UDP listener with restart support
 	restartUDPListener()
 	restartOSCService()
+	restartWebhooks()
+	go runTimingTower()
 
 	// Open browser to dashboard
 	go func() {
@@ -278,5 +327,12 @@ func main() {
 	if udpListenerStop != nil {
 		close(udpListenerStop)
 	}
+	stopRecording()
+	sessions.Lock()
+	SaveSessionHistory()
+	sessions.Unlock()
+	laps.Lock()
+	commitLapRecords()
+	laps.Unlock()
 	log.Println("[shutdown] Cleanup complete. Exiting.")
 }
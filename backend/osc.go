@@ -1,35 +1,337 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hypebeast/go-osc/osc"
 )
 
-// Centralized OSC send function
-func sendOSC(address string, value interface{}) {
-	if !Config.EnableOSC {
-		return
+const defaultOSCQueueSize = 1024
+
+// oscSender owns a single UDP socket to one OSC receiver. Packets are queued
+// and written by a dedicated goroutine so a slow or unreachable receiver can
+// never stall the UDP read loop; when the queue is full packets are dropped.
+type oscSender struct {
+	addr  string
+	port  int
+	conn  *net.UDPConn
+	queue chan osc.Packet
+	done  chan struct{}
+
+	sent     atomic.Uint64
+	dropped  atomic.Uint64
+	errors   atomic.Uint64
+	lastErrT atomic.Int64
+}
+
+func newOSCSender(addr string, port int, queueSize int) (*oscSender, error) {
+	if queueSize <= 0 {
+		queueSize = defaultOSCQueueSize
+	}
+	raddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", addr, port))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	s := &oscSender{
+		addr:  addr,
+		port:  port,
+		conn:  conn,
+		queue: make(chan osc.Packet, queueSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// enqueue never blocks, it reports false if the packet was dropped
+func (s *oscSender) enqueue(p osc.Packet) bool {
+	select {
+	case s.queue <- p:
+		return true
+	default:
+		s.dropped.Add(1)
+		return false
+	}
+}
+
+func (s *oscSender) run() {
+	defer close(s.done)
+	for p := range s.queue {
+		data, err := p.MarshalBinary()
+		if err == nil {
+			_, err = s.conn.Write(data)
+		}
+		if err != nil {
+			s.errors.Add(1)
+			// Unreachable receivers fail on every write, only log every 10s
+			if now := time.Now().Unix(); now-s.lastErrT.Load() > 10 {
+				log.Printf("[error] OSC send to %s:%d failed: %v", s.addr, s.port, err)
+				s.lastErrT.Store(now)
+			}
+			continue
+		}
+		s.sent.Add(1)
+	}
+}
+
+// close stops accepting packets, drains what is queued and closes the socket
+func (s *oscSender) close() {
+	close(s.queue)
+	<-s.done
+	s.conn.Close()
+}
+
+type oscSenderStats struct {
+	Name     string `json:"name"`
+	Target   string `json:"target"`
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Sent     uint64 `json:"sent"`
+	Dropped  uint64 `json:"dropped"`
+	Errors   uint64 `json:"errors"`
+}
+
+func (s *oscSender) stats(name string) oscSenderStats {
+	return oscSenderStats{
+		Name:     name,
+		Target:   fmt.Sprintf("%s:%d", s.addr, s.port),
+		Queued:   len(s.queue),
+		Capacity: cap(s.queue),
+		Sent:     s.sent.Load(),
+		Dropped:  s.dropped.Load(),
+		Errors:   s.errors.Load(),
 	}
-	// Convert unsupported types to supported OSC types
+}
+
+// oscValue converts Go values to types the OSC encoder supports. Integers
+// become int32, or int64 when they don't fit; a uint64 is reinterpreted.
+func oscValue(value interface{}) interface{} {
 	switch v := value.(type) {
 	case uint8:
-		value = int32(v)
+		return int32(v)
 	case uint16:
-		value = int32(v)
+		return int32(v)
 	case uint32:
-		value = int32(v)
+		if v > math.MaxInt32 {
+			return int64(v)
+		}
+		return int32(v)
+	case uint64:
+		return int64(v) // same bits, IDs above MaxInt64 turn negative
 	case int8:
-		value = int32(v)
+		return int32(v)
 	case int16:
-		value = int32(v)
+		return int32(v)
+	case int:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return int64(v)
+		}
+		return int32(v)
 	case float64:
-		value = float32(v)
+		return float32(v)
+	}
+	return value
+}
+
+// enqueueOSC holds oscClientMu while queueing so restartOSCService can't
+// close the queue in between; enqueue never blocks
+func enqueueOSC(destination string, p osc.Packet) {
+	oscClientMu.Lock()
+	defer oscClientMu.Unlock()
+	if sender := oscSenders[destination]; sender != nil {
+		sender.enqueue(p)
+	}
+}
+
+// Address of the message that leads every bundle, carrying the packet name,
+// frame identifier and session time the bundle belongs to
+const oscFrameAddress = "/frame"
+
+// oscFrame collects the OSC output of one decoded UDP packet until flush.
+// With Config.OSCBundles enabled everything is sent as one bundle timetagged
+// from the packet header, so receivers get frame-aligned updates; otherwise
+// each message is sent on its own. Collecting first means nothing is queued
+// while oscDestinationsMu is held.
+type oscFrame struct {
+	header     PacketHeader
+	packetName string
+	focusCar   int                       // car index single-car mappings follow, -1 if none
+	messages   map[string][]*osc.Message // per destination
+}
+
+func newOSCFrame(header PacketHeader, packetName string) *oscFrame {
+	return &oscFrame{header: header, packetName: packetName, focusCar: focusCarIndex(header)}
+}
+
+// sendMapping sends (or bundles) a mapped value to one destination. The
+// value is transformed, then coerced to the mapping's ValueType. Zero
+// suppression only applies to untransformed packet fields, a raw 0 often
+// maps to something else (centre steering remapped to 0..127) and derived
+// channels are often flags whose falling edge matters.
+func (f *oscFrame) sendMapping(b oscBinding, value interface{}) {
+	lastKey := b.destination + b.entry.Address
+	if b.transforms == nil {
+		if b.path.packet == derivedPacket {
+			if !changedSince(lastKey, value, lastSentOSC) {
+				return
+			}
+		} else {
+			// Only send 0 if AllowZero is true for this address
+			if isSuppressedZero(value) && !b.entry.AllowZero {
+				return
+			}
+			if !isSuppressedZero(value) && !shouldSend(lastKey, value, lastSentOSC) {
+				return
+			}
+		}
+		arg, err := coerceOSCValue(b.entry.ValueType, value)
+		if err != nil {
+			reportOSCCoercionError(b, err)
+			return
+		}
+		updateLastSent(lastKey, value, lastSentOSC)
+		f.addMapping(b, value, arg)
+		return
+	}
+	results, err := b.transforms.apply(value, f.focusCar)
+	if err != nil {
+		return // e.g. a ref packet that hasn't arrived yet
+	}
+	arg, err := coerceOSCValue(b.entry.ValueType, results[len(results)-1])
+	if err != nil {
+		reportOSCCoercionError(b, err)
+		return
+	}
+	if !changedSince(lastKey, arg, lastSentOSC) {
+		return
+	}
+	updateLastSent(lastKey, arg, lastSentOSC)
+	f.addMapping(b, value, arg)
+}
+
+// addMapping adds the message of a mapping, value is the raw packet value
+func (f *oscFrame) addMapping(b oscBinding, value, arg interface{}) {
+	msg := osc.NewMessage(b.entry.Address, arg)
+	if b.entry.Label {
+		if label := enumLabel(b.path.field, value); label != "" {
+			msg.Append(label)
+		}
+	}
+	f.add(b.destination, msg)
+}
+
+// sendEvent sends a discrete event to every destination mapping key. Unlike
+// mappings it is never throttled, deduplicated or zero-suppressed, and the
+// arguments keep their Go types.
+func (f *oscFrame) sendEvent(key string, args ...interface{}) {
+	if !Config.EnableOSC {
+		return
+	}
+	for i := range args {
+		args[i] = oscValue(args[i])
+	}
+	oscDestinationsMu.RLock()
+	defer oscDestinationsMu.RUnlock()
+	for _, dest := range OSCDestinations {
+		if !dest.Enabled {
+			continue
+		}
+		if entry, ok := dest.Addresses[key]; ok && entry.Enabled {
+			f.add(dest.Name, osc.NewMessage(entry.Address, args...))
+		}
+	}
+}
+
+// sendAddress sends a message to a fixed address on every enabled
+// destination, for outputs that have no mapping table entry (alerts)
+func (f *oscFrame) sendAddress(address string, args ...interface{}) {
+	if !Config.EnableOSC {
+		return
+	}
+	for i := range args {
+		args[i] = oscValue(args[i])
+	}
+	oscDestinationsMu.RLock()
+	defer oscDestinationsMu.RUnlock()
+	for _, dest := range OSCDestinations {
+		if dest.Enabled {
+			f.add(dest.Name, osc.NewMessage(address, args...))
+		}
+	}
+}
+
+func (f *oscFrame) add(destination string, msg *osc.Message) {
+	if Config.DebugOutput {
+		log.Printf("[debug] Sending OSC message to %s: %s %v", destination, msg.Address, msg.Arguments)
+	}
+	if f.messages == nil {
+		f.messages = make(map[string][]*osc.Message)
+	}
+	f.messages[destination] = append(f.messages[destination], msg)
+}
+
+// flush sends the collected messages or bundles, if any
+func (f *oscFrame) flush() {
+	if len(f.messages) == 0 {
+		return
+	}
+	if !Config.OSCBundles {
+		for destination, messages := range f.messages {
+			for _, msg := range messages {
+				enqueueOSC(destination, msg)
+			}
+		}
+		f.messages = nil
+		return
+	}
+	timetag := sessionTimeToWallClock(f.header)
+	for destination, messages := range f.messages {
+		bundle := osc.NewBundle(timetag)
+		bundle.Append(osc.NewMessage(oscFrameAddress, f.packetName, int32(f.header.FrameIdentifier), f.header.SessionTime))
+		for _, msg := range messages {
+			bundle.Append(msg)
+		}
+		enqueueOSC(destination, bundle)
+	}
+	f.messages = nil
+}
+
+// Wall clock time at SessionTime 0, per SessionUID, used to turn session
+// time into absolute OSC timetags. A flashback sets SessionTime back, the
+// clock is then re-anchored so timetags don't land in the past.
+var (
+	sessionClockMu     sync.Mutex
+	sessionClockUID    uint64
+	sessionClockAnchor time.Time
+	sessionClockLatest float32 // latest SessionTime seen
+)
+
+// Packets of one frame share a SessionTime and UDP may reorder a few, only
+// a bigger step back is a flashback
+const sessionClockRewindTolerance = 0.1 // seconds
+
+func sessionTimeToWallClock(header PacketHeader) time.Time {
+	sessionClockMu.Lock()
+	defer sessionClockMu.Unlock()
+	offset := time.Duration(float64(header.SessionTime) * float64(time.Second))
+	if sessionClockAnchor.IsZero() || sessionClockUID != header.SessionUID ||
+		header.SessionTime < sessionClockLatest-sessionClockRewindTolerance {
+		sessionClockUID = header.SessionUID
+		sessionClockAnchor = time.Now().Add(-offset)
+		sessionClockLatest = header.SessionTime
 	}
-	client := osc.NewClient(Config.OSCAddr, Config.OSCPort)
-	log.Printf("[debug] Sending OSC message: %s %v", address, value)
-	msg := osc.NewMessage(address, value)
-	if err := client.Send(msg); err != nil {
-		log.Printf("[error] OSC send failed: OSC - unsupported type: %T", value)
+	if header.SessionTime > sessionClockLatest {
+		sessionClockLatest = header.SessionTime
 	}
+	return sessionClockAnchor.Add(offset)
 }
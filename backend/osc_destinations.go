@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// OSCDestination is one OSC receiver (lighting desk, VJ tool...) with its own
+// address mapping table. New destinations start from OSCAddresses, which is
+// still persisted in osc_addresses.json as the default table.
+type OSCDestination struct {
+	Name      string                     `json:"name"`
+	Addr      string                     `json:"addr"`
+	Port      int                        `json:"port"`
+	Enabled   bool                       `json:"enabled"`
+	Addresses map[string]OSCAddressEntry `json:"addresses"`
+}
+
+// Name of the destination that is created from the legacy single OSC target
+// and kept in sync with Config.OSCAddr/OSCPort
+const defaultOSCDestination = "default"
+
+var OSCDestinations = []OSCDestination{}
+var oscDestinationsMu sync.RWMutex
+var oscDestinationsConfigPath string
+
+func InitOSCDestinationsConfig() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	appDir := filepath.Join(configDir, "f1-telem-bridge")
+	os.MkdirAll(appDir, 0755)
+	oscDestinationsConfigPath = filepath.Join(appDir, "osc_destinations.json")
+
+	if _, err := os.Stat(oscDestinationsConfigPath); os.IsNotExist(err) {
+		// Migrate the single OSC target and its address map
+		OSCDestinations = []OSCDestination{{
+			Name:      defaultOSCDestination,
+			Addr:      Config.OSCAddr,
+			Port:      Config.OSCPort,
+			Enabled:   true,
+			Addresses: copyOSCAddresses(OSCAddresses),
+		}}
+		SaveOSCDestinationsConfig()
+	} else {
+		LoadOSCDestinationsConfig()
+	}
+	rebuildOSCBindings()
+}
+
+func SaveOSCDestinationsConfig() {
+	f, err := os.Create(oscDestinationsConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not create OSC destinations config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(OSCDestinations); err != nil {
+		log.Printf("[error] Could not encode OSC destinations config: %v", err)
+	}
+}
+
+func LoadOSCDestinationsConfig() {
+	f, err := os.Open(oscDestinationsConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not open OSC destinations config file: %v", err)
+		return
+	}
+	defer f.Close()
+	var destinations []OSCDestination
+	if err := json.NewDecoder(f).Decode(&destinations); err != nil {
+		log.Printf("[error] Could not decode OSC destinations config: %v", err)
+		return
+	}
+	// Mappings added to the default table since a destination was saved are
+	// merged in disabled, so they can be turned on without changing what the
+	// destination sends
+	for i := range destinations {
+		if destinations[i].Addresses == nil {
+			destinations[i].Addresses = map[string]OSCAddressEntry{}
+		}
+		for key, entry := range OSCAddresses {
+			if _, ok := destinations[i].Addresses[key]; !ok {
+				entry.Enabled = false
+				destinations[i].Addresses[key] = entry
+			}
+		}
+	}
+	OSCDestinations = destinations
+}
+
+func copyOSCAddresses(src map[string]OSCAddressEntry) map[string]OSCAddressEntry {
+	dst := make(map[string]OSCAddressEntry, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// syncDefaultOSCDestination applies Config.OSCAddr/OSCPort to the default destination
+func syncDefaultOSCDestination() {
+	oscDestinationsMu.Lock()
+	for i := range OSCDestinations {
+		if OSCDestinations[i].Name == defaultOSCDestination {
+			OSCDestinations[i].Addr = Config.OSCAddr
+			OSCDestinations[i].Port = Config.OSCPort
+		}
+	}
+	SaveOSCDestinationsConfig()
+	oscDestinationsMu.Unlock()
+}
+
+func findOSCDestination(name string) int {
+	for i, d := range OSCDestinations {
+		if d.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateOSCDestination(d OSCDestination) error {
+	if d.Name == "" || strings.Contains(d.Name, "/") {
+		return fmt.Errorf("invalid destination name %q", d.Name)
+	}
+	if d.Addr == "" || d.Port <= 0 || d.Port > 65535 {
+		return fmt.Errorf("invalid destination address %s:%d", d.Addr, d.Port)
+	}
+	return nil
+}
+
+// REST API for OSC destinations:
+//
+//	GET/POST            /api/osc-destinations
+//	GET/PUT/DELETE      /api/osc-destinations/{name}
+//	GET/POST            /api/osc-destinations/{name}/addresses
+//	DELETE              /api/osc-destinations/{name}/addresses/{key}
+func handleOSCDestinationsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] OSCDestinations API handler crashed: %v", r)
+		}
+	}()
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/osc-destinations"), "/"), "/")
+	if parts[0] == "" {
+		handleOSCDestinationList(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		handleOSCDestination(w, r, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "addresses" {
+		handleOSCDestinationAddresses(w, r, parts[0])
+		return
+	}
+	if len(parts) == 3 && parts[1] == "addresses" {
+		handleOSCDestinationAddress(w, r, parts[0], parts[2])
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func handleOSCDestinationList(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		oscDestinationsMu.RLock()
+		defer oscDestinationsMu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OSCDestinations)
+	} else if r.Method == http.MethodPost {
+		var dest OSCDestination
+		if err := json.NewDecoder(r.Body).Decode(&dest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateOSCDestination(dest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if dest.Addresses == nil {
+			dest.Addresses = copyOSCAddresses(OSCAddresses)
+		}
+		oscDestinationsMu.Lock()
+		if findOSCDestination(dest.Name) >= 0 {
+			oscDestinationsMu.Unlock()
+			http.Error(w, "destination already exists", http.StatusConflict)
+			return
+		}
+		OSCDestinations = append(OSCDestinations, dest)
+		rebuildOSCBindings()
+		SaveOSCDestinationsConfig()
+		oscDestinationsMu.Unlock()
+		restartOSCService()
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func handleOSCDestination(w http.ResponseWriter, r *http.Request, name string) {
+	// Read the body before locking, the UDP loop reads destinations on every packet
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	oscDestinationsMu.Lock()
+	idx := findOSCDestination(name)
+	if idx < 0 {
+		oscDestinationsMu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		defer oscDestinationsMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OSCDestinations[idx])
+		return
+	case http.MethodPut:
+		dest := OSCDestinations[idx]
+		dest.Addresses = copyOSCAddresses(dest.Addresses)
+		err := json.Unmarshal(body, &dest)
+		if err == nil {
+			err = validateOSCDestination(dest)
+		}
+		if err == nil && dest.Name != name && findOSCDestination(dest.Name) >= 0 {
+			err = fmt.Errorf("destination %q already exists", dest.Name)
+		}
+		if err != nil {
+			oscDestinationsMu.Unlock()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		OSCDestinations[idx] = dest
+	case http.MethodDelete:
+		OSCDestinations = append(OSCDestinations[:idx], OSCDestinations[idx+1:]...)
+	default:
+		oscDestinationsMu.Unlock()
+		return
+	}
+	rebuildOSCBindings()
+	SaveOSCDestinationsConfig()
+	oscDestinationsMu.Unlock()
+	restartOSCService()
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleOSCDestinationAddresses(w http.ResponseWriter, r *http.Request, name string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	oscDestinationsMu.Lock()
+	defer oscDestinationsMu.Unlock()
+	idx := findOSCDestination(name)
+	if idx < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OSCDestinations[idx].Addresses)
+	} else if r.Method == http.MethodPost {
+		// The posted table replaces the existing one, left out keys are removed
+		addresses := map[string]OSCAddressEntry{}
+		if err := json.Unmarshal(body, &addresses); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		OSCDestinations[idx].Addresses = addresses
+		rebuildOSCBindings()
+		SaveOSCDestinationsConfig()
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleOSCDestinationAddress removes a single mapping from a destination
+func handleOSCDestinationAddress(w http.ResponseWriter, r *http.Request, name, key string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	oscDestinationsMu.Lock()
+	defer oscDestinationsMu.Unlock()
+	idx := findOSCDestination(name)
+	if idx < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := OSCDestinations[idx].Addresses[key]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	addresses := copyOSCAddresses(OSCDestinations[idx].Addresses)
+	delete(addresses, key)
+	OSCDestinations[idx].Addresses = addresses
+	rebuildOSCBindings()
+	SaveOSCDestinationsConfig()
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Defaults added after a destination was saved show up disabled, saved
+// mappings are kept as they are
+func TestLoadOSCDestinationsMergesNewDefaults(t *testing.T) {
+	savedPath, savedDestinations, savedAddresses := oscDestinationsConfigPath, OSCDestinations, OSCAddresses
+	t.Cleanup(func() {
+		oscDestinationsConfigPath, OSCDestinations, OSCAddresses = savedPath, savedDestinations, savedAddresses
+	})
+	oscDestinationsConfigPath = filepath.Join(t.TempDir(), "osc_destinations.json")
+	err := os.WriteFile(oscDestinationsConfigPath, []byte(`[
+		{"name": "desk", "addr": "127.0.0.1", "port": 9000, "enabled": true, "addresses": {
+			"CarTelemetry.Speed": {"address": "/desk/speed", "type": "int", "enabled": true}
+		}},
+		{"name": "empty", "addr": "127.0.0.1", "port": 9001, "enabled": true}
+	]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	OSCAddresses = map[string]OSCAddressEntry{
+		"CarTelemetry.Speed":    {Address: "/speed", ValueType: "float", Enabled: true},
+		"CarTelemetry.Gear":     {Address: "/gear", ValueType: "int", Enabled: true},
+		"Event_buttons":         {Address: "/event/buttons", ValueType: "event", Enabled: false},
+		"CarTelemetry.Throttle": {Address: "/throttle", Enabled: true, AllowZero: true},
+	}
+	LoadOSCDestinationsConfig()
+
+	if len(OSCDestinations) != 2 {
+		t.Fatalf("loaded %d destinations, want 2", len(OSCDestinations))
+	}
+	for _, d := range OSCDestinations {
+		if len(d.Addresses) != len(OSCAddresses) {
+			t.Errorf("%s has %d mappings, want %d", d.Name, len(d.Addresses), len(OSCAddresses))
+		}
+		for key, entry := range d.Addresses {
+			if d.Name == "desk" && key == "CarTelemetry.Speed" {
+				if entry.Address != "/desk/speed" || entry.ValueType != "int" || !entry.Enabled {
+					t.Errorf("desk: saved mapping changed to %+v", entry)
+				}
+				continue
+			}
+			if entry.Enabled {
+				t.Errorf("%s: merged default %s is enabled", d.Name, key)
+			}
+			if def := OSCAddresses[key]; entry.Address != def.Address || entry.AllowZero != def.AllowZero {
+				t.Errorf("%s: merged default %s = %+v, want %+v disabled", d.Name, key, entry, def)
+			}
+		}
+	}
+}
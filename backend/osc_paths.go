@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OSC mapping keys are field paths into a decoded packet:
+//
+//	Session.Weather                         a packet field
+//	LapData[3].CarPosition                  a field of car 3
+//	LapData[focus].CarPosition              a field of the focus car
+//	CarTelemetry.Speed                      same as CarTelemetry[focus].Speed
+//	CarTelemetry.TyresSurfaceTemperature[2] an array element (wheels are RL, RR, FL, FR)
+//	Session.WeatherForecastSamples[0].Weather
+//
+// The car selector after the packet name picks an entry of the packet's
+// per-car array. SessionHistory and TyreSets cover one car per packet, their
+// selector (the focus car by default) picks which car's packets are used.
+// Field names are case-insensitive. Older keys such as "Participant0_Name",
+// "Session_Weather" or the bare "Speed" are translated to paths.
+// Derived.<name> maps a derived channel (see derived.go).
+
+// Packets mappings can refer to. Bare legacy field names resolve to the
+// first packet in this order that has the field.
+var oscPathPackets = []struct {
+	name      string
+	typ       reflect.Type
+	singleCar bool
+}{
+	{"CarTelemetry", reflect.TypeOf(PacketCarTelemetryData{}), false},
+	{"CarStatus", reflect.TypeOf(PacketCarStatusData{}), false},
+	{"LapData", reflect.TypeOf(LapDataPacket{}), false},
+	{"CarDamage", reflect.TypeOf(PacketCarDamageData{}), false},
+	{"Motion", reflect.TypeOf(PacketMotionData{}), false},
+	{"MotionEx", reflect.TypeOf(PacketMotionExData{}), false},
+	{"Session", reflect.TypeOf(PacketSessionData{}), false},
+	{"Participants", reflect.TypeOf(PacketParticipantsData{}), false},
+	{"CarSetups", reflect.TypeOf(PacketCarSetupData{}), false},
+	{"FinalClassification", reflect.TypeOf(PacketFinalClassificationData{}), false},
+	{"LobbyInfo", reflect.TypeOf(PacketLobbyInfoData{}), false},
+	{"SessionHistory", reflect.TypeOf(PacketSessionHistoryData{}), true},
+	{"TyreSets", reflect.TypeOf(PacketTyreSetsData{}), true},
+	{"TimeTrial", reflect.TypeOf(PacketTimeTrialData{}), false},
+	{"LapPositions", reflect.TypeOf(PacketLapPositionsData{}), false},
+}
+
+const (
+	oscCarNone  = -2 // packet without cars
+	oscCarFocus = -1
+)
+
+var fixedNameType = reflect.TypeOf(FixedName{})
+
+type oscPathStep struct {
+	field []int // struct field, nil for an array index
+	index int   // array index, oscCarFocus for the focus car
+}
+
+// oscPath is a mapping key compiled against its packet type
+type oscPath struct {
+	packet    string
+	car       int   // car selector, oscCarNone, oscCarFocus or an index
+	carArray  []int // the per-car array field, nil if the path doesn't go through it
+	singleCar bool
+	steps     []oscPathStep
+	field     string       // name of the last field, for display names
+	leaf      reflect.Type // type of the mapped value
+	derived   string       // derived channel name for Derived.<name> paths
+}
+
+// resolve returns the mapped value of a packet, ok is false when the packet
+// doesn't cover the selected car
+func (p *oscPath) resolve(v reflect.Value, packetCar, focusCar int) (any, bool) {
+	car := p.car
+	if car == oscCarFocus {
+		car = focusCar
+		if car < 0 {
+			return nil, false
+		}
+	}
+	if p.singleCar && packetCar != car {
+		return nil, false
+	}
+	if p.derived != "" {
+		// v holds the channels that were just evaluated
+		value := v.MapIndex(reflect.ValueOf(p.derived))
+		if !value.IsValid() {
+			return nil, false
+		}
+		return value.Interface(), true
+	}
+	if p.carArray != nil {
+		v = v.FieldByIndex(p.carArray).Index(car)
+	}
+	for _, step := range p.steps {
+		if step.field != nil {
+			v = v.FieldByIndex(step.field)
+			continue
+		}
+		index := step.index
+		if index == oscCarFocus {
+			if focusCar < 0 {
+				return nil, false
+			}
+			index = focusCar
+		}
+		v = v.Index(index)
+	}
+	if name, ok := v.Interface().(FixedName); ok {
+		return name.String(), true
+	}
+	return v.Interface(), true
+}
+
+var oscPathToken = regexp.MustCompile(`^(\.?[A-Za-z][A-Za-z0-9]*|\[(?:\d+|focus)\])`)
+
+// compileOSCPath parses a path and checks it against the packet type
+func compileOSCPath(path string) (*oscPath, error) {
+	var tokens []string
+	for rest := path; rest != ""; {
+		token := oscPathToken.FindString(rest)
+		if token == "" {
+			return nil, fmt.Errorf("invalid path syntax at %q", rest)
+		}
+		tokens = append(tokens, token)
+		rest = rest[len(token):]
+	}
+	if len(tokens) == 0 || strings.HasPrefix(tokens[0], "[") || strings.HasPrefix(tokens[0], ".") {
+		return nil, fmt.Errorf("path must start with a packet name")
+	}
+
+	if strings.EqualFold(tokens[0], derivedPacket) {
+		if len(tokens) != 2 || !strings.HasPrefix(tokens[1], ".") {
+			return nil, fmt.Errorf("derived channels are mapped as Derived.<name>")
+		}
+		name, ok := derivedChannelName(tokens[1][1:])
+		if !ok {
+			return nil, fmt.Errorf("no enabled, valid derived channel %s", tokens[1][1:])
+		}
+		return &oscPath{packet: derivedPacket, car: oscCarNone, field: name, leaf: reflect.TypeOf(float64(0)), derived: name}, nil
+	}
+
+	p := &oscPath{packet: tokens[0], car: oscCarNone}
+	var t reflect.Type
+	for _, packet := range oscPathPackets {
+		if strings.EqualFold(packet.name, tokens[0]) {
+			p.packet, t, p.singleCar = packet.name, packet.typ, packet.singleCar
+		}
+	}
+	if t == nil {
+		return nil, fmt.Errorf("unknown packet %q", tokens[0])
+	}
+	tokens = tokens[1:]
+	carArray, carElem := perCarArray(t)
+	if p.singleCar {
+		p.car = oscCarFocus
+	}
+
+	// Car selector
+	if len(tokens) > 0 && strings.HasPrefix(tokens[0], "[") {
+		index, err := parseOSCIndex(tokens[0], 22)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case carArray != nil:
+			p.carArray = carArray
+			t = carElem
+		case !p.singleCar:
+			return nil, fmt.Errorf("%s has no per-car data", p.packet)
+		}
+		p.car = index
+		tokens = tokens[1:]
+	} else if carArray != nil && len(tokens) > 0 {
+		// A field that isn't on the packet itself belongs to the focus car
+		if _, ok := findField(t, tokens[0][1:]); !ok {
+			if _, ok := findField(carElem, tokens[0][1:]); ok {
+				p.carArray, p.car, t = carArray, oscCarFocus, carElem
+			}
+		}
+	}
+
+	for _, token := range tokens {
+		if !strings.HasPrefix(token, ".") && !strings.HasPrefix(token, "[") {
+			return nil, fmt.Errorf("missing . before %s", token)
+		}
+		if strings.HasPrefix(token, ".") {
+			if t.Kind() != reflect.Struct || t == fixedNameType {
+				return nil, fmt.Errorf("%s has no field %s", t, token[1:])
+			}
+			field, ok := findField(t, token[1:])
+			if !ok {
+				return nil, fmt.Errorf("%s has no field %s", t.Name(), token[1:])
+			}
+			p.steps = append(p.steps, oscPathStep{field: field.Index})
+			p.field = field.Name
+			t = field.Type
+			continue
+		}
+		if t.Kind() != reflect.Array || t == fixedNameType {
+			return nil, fmt.Errorf("%s is not an array", p.field)
+		}
+		index, err := parseOSCIndex(token, t.Len())
+		if err != nil {
+			return nil, err
+		}
+		if index == oscCarFocus && t.Len() != 22 {
+			return nil, fmt.Errorf("[focus] only indexes per-car arrays")
+		}
+		p.steps = append(p.steps, oscPathStep{index: index})
+		t = t.Elem()
+	}
+	if t != fixedNameType && (t.Kind() == reflect.Struct || t.Kind() == reflect.Array) {
+		return nil, fmt.Errorf("path ends at %s, not a single value", t)
+	}
+	p.leaf = t
+	return p, nil
+}
+
+func parseOSCIndex(token string, length int) (int, error) {
+	inner := token[1 : len(token)-1]
+	if inner == "focus" {
+		return oscCarFocus, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil || index >= length {
+		return 0, fmt.Errorf("index %s out of range 0-%d", inner, length-1)
+	}
+	return index, nil
+}
+
+func findField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	return t.FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+}
+
+// perCarArray finds the [22] array of structs of a packet type
+func perCarArray(t reflect.Type) ([]int, reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Array && field.Type.Len() == 22 && field.Type.Elem().Kind() == reflect.Struct {
+			return field.Index, field.Type.Elem()
+		}
+	}
+	return nil, nil
+}
+
+var legacyOSCKeys = []struct {
+	pattern *regexp.Regexp
+	path    string
+}{
+	{regexp.MustCompile(`^Session_WeatherForecastSample(\d+)_(\w+)$`), "Session.WeatherForecastSamples[$1].$2"},
+	{regexp.MustCompile(`^Session_MarshalZone(\d+)_(\w+)$`), "Session.MarshalZones[$1].$2"},
+	{regexp.MustCompile(`^Session_(\w+)$`), "Session.$1"},
+	{regexp.MustCompile(`^Participant(\d+)_(\w+)$`), "Participants[$1].$2"},
+	{regexp.MustCompile(`^CarSetup(\d+)_(\w+)$`), "CarSetups[$1].$2"},
+	{regexp.MustCompile(`^FinalClassification(\d+)_(\w+)$`), "FinalClassification[$1].$2"},
+	{regexp.MustCompile(`^LobbyPlayer(\d+)_(\w+)$`), "LobbyInfo[$1].$2"},
+	{regexp.MustCompile(`^SessionHistory_Lap(\d+)_(\w+)$`), "SessionHistory.LapHistoryData[$1].$2"},
+	{regexp.MustCompile(`^TyreSet(\d+)_(\w+)$`), "TyreSets.TyreSetData[$1].$2"},
+	{regexp.MustCompile(`^LapPositions_Lap(\d+)_Car(\d+)$`), "LapPositions.PositionForVehicleIdx[$1][$2]"},
+	{regexp.MustCompile(`^TimeTrial_PlayerBest_(\w+)$`), "TimeTrial.PlayerSessionBestDataSet.$1"},
+	{regexp.MustCompile(`^TimeTrial_(PersonalBest|Rival)_(\w+)$`), "TimeTrial.${1}DataSet.$2"},
+}
+
+var wheelSuffixes = []string{"RL", "RR", "FL", "FR"}
+
+// oscKeyPath returns the field path of a mapping key, translating older keys
+func oscKeyPath(key string) (string, error) {
+	if strings.ContainsAny(key, ".[") {
+		return key, nil
+	}
+	for _, legacy := range legacyOSCKeys {
+		if legacy.pattern.MatchString(key) {
+			return legacy.pattern.ReplaceAllString(key, legacy.path), nil
+		}
+	}
+	// A bare field name, optionally with a wheel suffix, of the focus car
+	name, wheel := key, -1
+	for i, suffix := range wheelSuffixes {
+		if base, ok := strings.CutSuffix(key, suffix); ok && base != "" {
+			name, wheel = base, i
+		}
+	}
+	for _, packet := range oscPathPackets {
+		_, elem := perCarArray(packet.typ)
+		for _, t := range []reflect.Type{packet.typ, elem} {
+			if t == nil {
+				continue
+			}
+			if _, ok := findField(t, key); ok {
+				return packet.name + "." + key, nil
+			}
+			if field, ok := findField(t, name); ok && wheel >= 0 && field.Type.Kind() == reflect.Array && field.Type.Len() == 4 {
+				return fmt.Sprintf("%s.%s[%d]", packet.name, name, wheel), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no packet has a field %s", key)
+}
+
+// oscBinding is one enabled mapping of a destination
+type oscBinding struct {
+	destination string
+	key         string
+	entry       OSCAddressEntry
+	path        *oscPath
+	transforms  *oscTransformChain // nil without transforms
+}
+
+// oscMappingStatus is a mapping as reported by /api/osc/mappings
+type oscMappingStatus struct {
+	Destination string `json:"destination"`
+	Key         string `json:"key"`
+	Address     string `json:"address"`
+	Enabled     bool   `json:"enabled"`
+	Path        string `json:"path,omitempty"`
+	Error       string `json:"error,omitempty"`
+	SendError   string `json:"sendError,omitempty"` // latest value that couldn't be coerced to the type
+}
+
+// Compiled mappings by packet name, guarded by oscDestinationsMu
+var (
+	oscBindings       map[string][]oscBinding
+	oscMappingReports []oscMappingStatus
+)
+
+// rebuildOSCBindings compiles the mappings of every destination, expects
+// oscDestinationsMu to be locked (or no concurrent access during startup).
+// Event mappings are sent by name and have no path.
+func rebuildOSCBindings() {
+	bindings := map[string][]oscBinding{}
+	reports := []oscMappingStatus{}
+	for _, dest := range OSCDestinations {
+		for key, entry := range dest.Addresses {
+			if entry.ValueType == "event" {
+				continue
+			}
+			report := oscMappingStatus{Destination: dest.Name, Key: key, Address: entry.Address, Enabled: entry.Enabled}
+			path, err := oscKeyPath(key)
+			var compiled *oscPath
+			var transforms *oscTransformChain
+			if err == nil {
+				report.Path = path
+				compiled, err = compileOSCPath(path)
+			}
+			if err == nil {
+				err = checkOSCValueType(entry.ValueType, compiled)
+			}
+			if err == nil {
+				transforms, err = compileOSCTransforms(entry.Transforms)
+			}
+			if err != nil {
+				report.Error = err.Error()
+			} else if entry.Enabled {
+				bindings[compiled.packet] = append(bindings[compiled.packet], oscBinding{destination: dest.Name, key: key, entry: entry, path: compiled, transforms: transforms})
+			}
+			reports = append(reports, report)
+		}
+	}
+	for _, list := range bindings {
+		sort.Slice(list, func(i, j int) bool { return list[i].key < list[j].key })
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Destination != reports[j].Destination {
+			return reports[i].Destination < reports[j].Destination
+		}
+		return reports[i].Key < reports[j].Key
+	})
+	oscBindings = bindings
+	oscMappingReports = reports
+	clearOSCCoercionErrors()
+}
+
+// logOSCMappingProblems is the startup validation report
+func logOSCMappingProblems() {
+	oscDestinationsMu.RLock()
+	defer oscDestinationsMu.RUnlock()
+	problems := 0
+	for _, report := range oscMappingReports {
+		if report.Error != "" {
+			problems++
+			log.Printf("[config] OSC mapping %s/%s (%s) is invalid: %s", report.Destination, report.Key, report.Address, report.Error)
+		}
+	}
+	log.Printf("[config] %d OSC mappings checked, %d invalid", len(oscMappingReports), problems)
+}
+
+// sendPacketToOSC sends every mapping of a packet. packetCar is the car of
+// single-car packets, -1 otherwise.
+func sendPacketToOSC(packetName string, v reflect.Value, packetCar int, frame *oscFrame) {
+	if !Config.EnableOSC {
+		return
+	}
+	oscDestinationsMu.RLock()
+	defer oscDestinationsMu.RUnlock()
+	for _, b := range oscBindings[packetName] {
+		value, ok := b.path.resolve(v, packetCar, frame.focusCar)
+		if !ok {
+			continue
+		}
+		frame.sendMapping(b, value)
+	}
+}
+
+// REST API for the mapping validation report:
+//
+//	GET /api/osc/mappings          every mapping with its resolved path
+//	GET /api/osc/mappings?errors=1 only the mappings that are invalid or failed to send
+func handleOSCMappingsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] OSC mappings API handler crashed: %v", r)
+		}
+	}()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	onlyErrors := r.URL.Query().Get("errors") == "1"
+	oscDestinationsMu.RLock()
+	reports := []oscMappingStatus{}
+	for _, report := range oscMappingReports {
+		report.SendError = oscCoercionError(report.Destination, report.Key)
+		if !onlyErrors || report.Error != "" || report.SendError != "" {
+			reports = append(reports, report)
+		}
+	}
+	oscDestinationsMu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
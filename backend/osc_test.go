@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// After a flashback SessionTime goes back, timetags must not land in the past
+func TestSessionClockFlashback(t *testing.T) {
+	t.Cleanup(func() {
+		sessionClockMu.Lock()
+		sessionClockAnchor = time.Time{}
+		sessionClockMu.Unlock()
+	})
+	header := PacketHeader{SessionUID: 7, SessionTime: 100}
+	start := sessionTimeToWallClock(header)
+
+	header.SessionTime = 100.5
+	if got := sessionTimeToWallClock(header).Sub(start); got != 500*time.Millisecond {
+		t.Errorf("0.5s later: %v after the start, want 500ms", got)
+	}
+	// A packet of the previous frame, within the tolerance
+	header.SessionTime = 100.4375
+	if got := sessionTimeToWallClock(header).Sub(start); got != 437500*time.Microsecond {
+		t.Errorf("reordered packet: %v after the start, want 437.5ms", got)
+	}
+
+	header.SessionTime = 90 // flashback
+	now := time.Now()
+	if got := sessionTimeToWallClock(header); got.Before(now) {
+		t.Errorf("flashback timetag is %v in the past", now.Sub(got))
+	}
+	header.SessionTime = 91
+	if got := sessionTimeToWallClock(header).Sub(now); got < time.Second || got > 2*time.Second {
+		t.Errorf("1s after the flashback: %v after it, want about 1s", got)
+	}
+}
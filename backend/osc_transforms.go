@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+)
+
+// OSCTransform is one step of a mapping's transform chain. Steps run in
+// order on the numeric value (bools are 0/1) before it is sent:
+//
+//	{"type":"scale","factor":0.001}                    multiply
+//	{"type":"scale","from":[-1,1],"to":[0,127]}        linear remap
+//	{"type":"scale","ref":"CarStatus.MaxRPM","to":[0,1]} remap 0..ref to the range
+//	{"type":"offset","value":-273.15}                  add
+//	{"type":"clamp","min":0,"max":120}                 limit, either bound optional
+//	{"type":"invert","min":0,"max":1}                  max - (value - min), -value without a range
+//	{"type":"curve","k":3}                             exponential, (e^(k*v)-1)/(e^k-1), sign kept
+//	{"type":"power","exponent":2}                      value^exponent, sign kept
+//	{"type":"quantize","step":0.05}                    round to a multiple of step
+//	{"type":"unit","unit":"kmh_to_mph"}                unit conversion, see oscUnits
+type OSCTransform struct {
+	Type     string    `json:"type"`
+	Factor   float64   `json:"factor,omitempty"`
+	From     []float64 `json:"from,omitempty"`
+	To       []float64 `json:"to,omitempty"`
+	Ref      string    `json:"ref,omitempty"`
+	Value    float64   `json:"value,omitempty"`
+	Min      *float64  `json:"min,omitempty"`
+	Max      *float64  `json:"max,omitempty"`
+	K        float64   `json:"k,omitempty"`
+	Exponent float64   `json:"exponent,omitempty"`
+	Step     float64   `json:"step,omitempty"`
+	Unit     string    `json:"unit,omitempty"`
+}
+
+var oscUnits = map[string]func(float64) float64{
+	"kmh_to_mph":  func(v float64) float64 { return v * 0.621371 },
+	"kmh_to_ms":   func(v float64) float64 { return v / 3.6 },
+	"ms_to_kmh":   func(v float64) float64 { return v * 3.6 },
+	"c_to_f":      func(v float64) float64 { return v*9/5 + 32 },
+	"c_to_k":      func(v float64) float64 { return v + 273.15 },
+	"psi_to_bar":  func(v float64) float64 { return v * 0.0689476 },
+	"psi_to_kpa":  func(v float64) float64 { return v * 6.89476 },
+	"rad_to_deg":  func(v float64) float64 { return v * 180 / math.Pi },
+	"deg_to_rad":  func(v float64) float64 { return v * math.Pi / 180 },
+	"ms_to_s":     func(v float64) float64 { return v / 1000 },
+	"s_to_ms":     func(v float64) float64 { return v * 1000 },
+	"m_to_km":     func(v float64) float64 { return v / 1000 },
+	"kg_to_lb":    func(v float64) float64 { return v * 2.20462 },
+	"j_to_mj":     func(v float64) float64 { return v / 1e6 },
+	"g_to_ms2":    func(v float64) float64 { return v * 9.80665 },
+	"ratio_to_pc": func(v float64) float64 { return v * 100 },
+}
+
+// oscTransformChain is a mapping's transform list with its ref paths compiled
+type oscTransformChain struct {
+	steps []OSCTransform
+	refs  []*oscPath // per step, nil without ref
+}
+
+func compileOSCTransforms(steps []OSCTransform) (*oscTransformChain, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	steps = append([]OSCTransform(nil), steps...)
+	chain := &oscTransformChain{steps: steps, refs: make([]*oscPath, len(steps))}
+	for i, t := range steps {
+		// Mappings saved before curve became exponential meant a power
+		if t.Type == "curve" && t.K == 0 && t.Exponent != 0 {
+			t.Type = "power"
+			steps[i] = t
+		}
+		if err := validateOSCTransform(t); err != nil {
+			return nil, fmt.Errorf("transform %d (%s): %v", i, t.Type, err)
+		}
+		if t.Ref != "" {
+			ref, err := compileOSCPath(t.Ref)
+			if err != nil {
+				return nil, fmt.Errorf("transform %d (%s): ref: %v", i, t.Type, err)
+			}
+			chain.refs[i] = ref
+		}
+	}
+	return chain, nil
+}
+
+func validateOSCTransform(t OSCTransform) error {
+	switch t.Type {
+	case "scale":
+		if t.From != nil && len(t.From) != 2 || t.To != nil && len(t.To) != 2 {
+			return fmt.Errorf("from and to are [min, max] pairs")
+		}
+		if t.To == nil && t.Factor == 0 {
+			return fmt.Errorf("needs a factor or a to range")
+		}
+		if t.To != nil && t.From == nil && t.Ref == "" {
+			return fmt.Errorf("a to range needs a from range or a ref")
+		}
+		if t.From != nil && t.Ref == "" && t.From[0] == t.From[1] {
+			return fmt.Errorf("empty from range")
+		}
+	case "offset":
+	case "clamp":
+		if t.Min == nil && t.Max == nil {
+			return fmt.Errorf("needs min or max")
+		}
+	case "invert":
+		if (t.Min == nil) != (t.Max == nil) {
+			return fmt.Errorf("needs both min and max, or neither")
+		}
+	case "curve":
+		if t.K == 0 {
+			return fmt.Errorf("k must not be 0")
+		}
+	case "power":
+		if t.Exponent <= 0 {
+			return fmt.Errorf("exponent must be positive")
+		}
+	case "quantize":
+		if t.Step <= 0 {
+			return fmt.Errorf("step must be positive")
+		}
+	case "unit":
+		if oscUnits[t.Unit] == nil {
+			return fmt.Errorf("unknown unit %q", t.Unit)
+		}
+	default:
+		return fmt.Errorf("unknown transform")
+	}
+	return nil
+}
+
+// apply runs the chain. It returns the value after every step, the last
+// one is the result.
+func (c *oscTransformChain) apply(value any, focusCar int) ([]float64, error) {
+	v, ok := oscNumber(value)
+	if !ok {
+		return nil, fmt.Errorf("can't transform %T", value)
+	}
+	results := make([]float64, 0, len(c.steps))
+	for i, t := range c.steps {
+		switch t.Type {
+		case "scale":
+			if t.To == nil {
+				v *= t.Factor
+				break
+			}
+			from := t.From
+			if c.refs[i] != nil {
+				refValue, ok := liveOSCValue(c.refs[i], focusCar)
+				if !ok {
+					return results, fmt.Errorf("ref %s has no value yet", t.Ref)
+				}
+				max, _ := oscNumber(refValue)
+				lo := 0.0
+				if from != nil {
+					lo = from[0]
+				}
+				from = []float64{lo, max}
+			}
+			if from[0] == from[1] {
+				return results, fmt.Errorf("empty from range")
+			}
+			v = t.To[0] + (v-from[0])*(t.To[1]-t.To[0])/(from[1]-from[0])
+		case "offset":
+			v += t.Value
+		case "clamp":
+			if t.Min != nil && v < *t.Min {
+				v = *t.Min
+			}
+			if t.Max != nil && v > *t.Max {
+				v = *t.Max
+			}
+		case "invert":
+			if t.Min != nil {
+				v = *t.Max - (v - *t.Min)
+			} else {
+				v = -v
+			}
+		case "curve":
+			// Maps 0..1 onto 0..1, k > 0 eases in, k < 0 eases out
+			v = math.Copysign(math.Expm1(t.K*math.Abs(v))/math.Expm1(t.K), v)
+		case "power":
+			v = math.Copysign(math.Pow(math.Abs(v), t.Exponent), v)
+		case "quantize":
+			v = math.Round(v/t.Step) * t.Step
+		case "unit":
+			v = oscUnits[t.Unit](v)
+		}
+		results = append(results, v)
+	}
+	return results, nil
+}
+
+func oscNumber(value any) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// liveOSCValue resolves a path against the latest packets in the live state
+func liveOSCValue(path *oscPath, focusCar int) (any, bool) {
+	liveState.RLock()
+	defer liveState.RUnlock()
+	if path.singleCar {
+		car := path.car
+		if car == oscCarFocus {
+			car = focusCar
+		}
+		pkt, ok := liveState.perCar[path.packet][car]
+		if !ok {
+			return nil, false
+		}
+		return path.resolve(reflect.ValueOf(pkt), car, focusCar)
+	}
+	pkt, ok := liveState.packets[path.packet]
+	if !ok {
+		return nil, false
+	}
+	return path.resolve(reflect.ValueOf(pkt), -1, focusCar)
+}
+
+type oscPreviewRequest struct {
+	Destination string           `json:"destination"`
+	Key         string           `json:"key"`
+	Entry       *OSCAddressEntry `json:"entry,omitempty"` // unsaved mapping to try, defaults to the saved one
+}
+
+type oscPreviewStep struct {
+	Type  string  `json:"type"`
+	Value float64 `json:"value"`
+}
+
+type oscPreviewResponse struct {
+	Destination string           `json:"destination"`
+	Key         string           `json:"key"`
+	Address     string           `json:"address"`
+	Path        string           `json:"path"`
+	Raw         any              `json:"raw"`
+	Steps       []oscPreviewStep `json:"steps"`
+	Value       any              `json:"value"` // the OSC argument
+	Error       string           `json:"error,omitempty"`
+}
+
+// REST API to preview a mapping against the live value:
+//
+//	GET  /api/osc/preview?destination=default&key=EngineRPM
+//	POST /api/osc/preview {"destination":"default","key":"EngineRPM","entry":{...}}
+func handleOSCPreviewAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] OSC preview API handler crashed: %v", r)
+		}
+	}()
+
+	req := oscPreviewRequest{Destination: r.URL.Query().Get("destination"), Key: r.URL.Query().Get("key")}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Destination == "" {
+		req.Destination = defaultOSCDestination
+	}
+	if req.Entry == nil {
+		oscDestinationsMu.RLock()
+		idx := findOSCDestination(req.Destination)
+		if idx >= 0 {
+			if entry, ok := OSCDestinations[idx].Addresses[req.Key]; ok {
+				req.Entry = &entry
+			}
+		}
+		oscDestinationsMu.RUnlock()
+		if req.Entry == nil {
+			http.Error(w, "mapping not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	resp := oscPreviewResponse{Destination: req.Destination, Key: req.Key, Address: req.Entry.Address, Steps: []oscPreviewStep{}}
+	w.Header().Set("Content-Type", "application/json")
+	defer json.NewEncoder(w).Encode(&resp)
+	path, err := oscKeyPath(req.Key)
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	resp.Path = path
+	compiled, err := compileOSCPath(path)
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	if err = checkOSCValueType(req.Entry.ValueType, compiled); err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	chain, err := compileOSCTransforms(req.Entry.Transforms)
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	focusState.Lock()
+	focusCar := resolveFocusCar(focusState.lastPlayer)
+	focusState.Unlock()
+	raw, ok := liveOSCValue(compiled, focusCar)
+	if !ok {
+		resp.Error = "no live value yet"
+		return
+	}
+	resp.Raw = raw
+	value := raw
+	if chain != nil {
+		results, err := chain.apply(raw, focusCar)
+		for i, v := range results {
+			resp.Steps = append(resp.Steps, oscPreviewStep{Type: chain.steps[i].Type, Value: v})
+		}
+		if err != nil {
+			resp.Error = err.Error()
+			return
+		}
+		value = results[len(results)-1]
+	}
+	if resp.Value, err = coerceOSCValue(req.Entry.ValueType, value); err != nil {
+		resp.Error = err.Error()
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func floatPtr(v float64) *float64 { return &v }
+
+func TestOSCTransforms(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []OSCTransform
+		in    any
+		want  float64
+	}{
+		{"scale factor", []OSCTransform{{Type: "scale", Factor: 0.001}}, uint16(12000), 12},
+		{"scale range", []OSCTransform{{Type: "scale", From: []float64{-1, 1}, To: []float64{0, 127}}}, float32(1), 127},
+		{"scale range raw 0", []OSCTransform{{Type: "scale", From: []float64{-1, 1}, To: []float64{0, 127}}}, float32(0), 63.5},
+		{"offset", []OSCTransform{{Type: "offset", Value: -273.15}}, float64(300), 26.85},
+		{"clamp max", []OSCTransform{{Type: "clamp", Min: floatPtr(0), Max: floatPtr(120)}}, uint16(900), 120},
+		{"clamp min only", []OSCTransform{{Type: "clamp", Min: floatPtr(10)}}, int8(-5), 10},
+		{"clamp inside", []OSCTransform{{Type: "clamp", Max: floatPtr(120)}}, uint16(90), 90},
+		{"invert range", []OSCTransform{{Type: "invert", Min: floatPtr(0), Max: floatPtr(1)}}, float32(0.25), 0.75},
+		{"invert range raw 0", []OSCTransform{{Type: "invert", Min: floatPtr(0), Max: floatPtr(1)}}, float32(0), 1},
+		{"invert negate", []OSCTransform{{Type: "invert"}}, int16(-3), 3},
+		{"curve ends", []OSCTransform{{Type: "curve", K: 3}}, float64(1), 1},
+		{"curve raw 0", []OSCTransform{{Type: "curve", K: 3}}, float64(0), 0},
+		{"curve eases in", []OSCTransform{{Type: "curve", K: 3}}, float64(0.5), (math.Exp(1.5) - 1) / (math.Exp(3) - 1)},
+		{"curve eases out", []OSCTransform{{Type: "curve", K: -3}}, float64(0.5), (math.Exp(-1.5) - 1) / (math.Exp(-3) - 1)},
+		{"curve keeps sign", []OSCTransform{{Type: "curve", K: 3}}, float64(-1), -1},
+		{"power", []OSCTransform{{Type: "power", Exponent: 2}}, float64(-3), -9},
+		{"saved power curve", []OSCTransform{{Type: "curve", Exponent: 2}}, float64(3), 9},
+		{"quantize", []OSCTransform{{Type: "quantize", Step: 0.05}}, float64(0.337), 0.35},
+		{"unit", []OSCTransform{{Type: "unit", Unit: "kmh_to_ms"}}, uint16(36), 10},
+		{"bool", []OSCTransform{{Type: "scale", Factor: 127}}, true, 127},
+		{"chain", []OSCTransform{
+			{Type: "unit", Unit: "c_to_f"},
+			{Type: "offset", Value: -32},
+			{Type: "clamp", Max: floatPtr(100)},
+			{Type: "quantize", Step: 10},
+		}, float64(50), 90},
+	}
+	for _, tt := range tests {
+		chain, err := compileOSCTransforms(tt.steps)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		results, err := chain.apply(tt.in, -1)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(results) != len(tt.steps) {
+			t.Errorf("%s: %d results for %d steps", tt.name, len(results), len(tt.steps))
+		}
+		if got := results[len(results)-1]; math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: %v -> %v, want %v", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOSCTransformValidation(t *testing.T) {
+	invalid := []OSCTransform{
+		{Type: "scale"},
+		{Type: "scale", To: []float64{0, 1}},
+		{Type: "scale", From: []float64{1, 1}, To: []float64{0, 1}},
+		{Type: "scale", From: []float64{0}, To: []float64{0, 1}},
+		{Type: "clamp"},
+		{Type: "invert", Min: floatPtr(0)},
+		{Type: "curve"},
+		{Type: "power", Exponent: -1},
+		{Type: "quantize"},
+		{Type: "unit", Unit: "furlongs"},
+		{Type: "sqrt"},
+	}
+	for _, step := range invalid {
+		if _, err := compileOSCTransforms([]OSCTransform{step}); err == nil {
+			t.Errorf("%+v: no error", step)
+		}
+	}
+}
+
+// A raw 0 goes through the chain even without allowZero, centre steering
+// must reach the receiver as the middle of the remapped range
+func TestOSCTransformedZeroIsSent(t *testing.T) {
+	chain, err := compileOSCTransforms([]OSCTransform{{Type: "scale", From: []float64{-1, 1}, To: []float64{0, 127}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := compileOSCPath("CarTelemetry.Steer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := oscBinding{
+		destination: "test",
+		key:         "CarTelemetry.Steer",
+		entry:       OSCAddressEntry{Address: "/test/transformed-zero", ValueType: "float", Enabled: true},
+		path:        path,
+		transforms:  chain,
+	}
+	frame := &oscFrame{focusCar: -1}
+	frame.sendMapping(b, float32(0))
+	messages := frame.messages["test"]
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if got := messages[0].Arguments; len(got) != 1 || got[0] != float32(63.5) {
+		t.Errorf("arguments = %v, want [63.5]", got)
+	}
+
+	// Without transforms a raw 0 is still suppressed
+	b.transforms = nil
+	b.entry.Address = "/test/raw-zero"
+	frame = &oscFrame{focusCar: -1}
+	frame.sendMapping(b, float32(0))
+	if n := len(frame.messages["test"]); n != 0 {
+		t.Errorf("got %d messages for a raw 0, want none", n)
+	}
+}
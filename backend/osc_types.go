@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// OSC argument types a mapping's ValueType can ask for. An empty type keeps
+// the Go type, widening integers that don't fit an int32.
+var oscValueTypes = map[string]string{
+	"":       "",
+	"int":    "i",
+	"int64":  "h",
+	"float":  "f",
+	"double": "d",
+	"bool":   "T/F",
+	"string": "s",
+	"blob":   "b",
+}
+
+// coerceOSCValue converts a decoded value to the OSC argument of valueType
+func coerceOSCValue(valueType string, value any) (any, error) {
+	if name, ok := value.(FixedName); ok {
+		value = name.String()
+	}
+	v := reflect.ValueOf(value)
+	switch valueType {
+	case "":
+		return oscValue(value), nil
+	case "string":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if _, ok := oscNumber(value); ok {
+			return fmt.Sprint(value), nil
+		}
+	case "blob":
+		switch x := value.(type) {
+		case string:
+			return []byte(x), nil
+		case []byte:
+			return x, nil
+		}
+		// Numbers as they are encoded in the UDP packets, little endian
+		if _, ok := oscNumber(value); ok && v.Kind() != reflect.Int && v.Kind() != reflect.Uint {
+			var buf bytes.Buffer
+			binary.Write(&buf, binary.LittleEndian, value)
+			return buf.Bytes(), nil
+		}
+	case "bool":
+		if n, ok := oscNumber(value); ok {
+			return n != 0, nil
+		}
+		if s, ok := value.(string); ok {
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b, nil
+			}
+		}
+	case "float", "double":
+		n, ok := oscNumber(value)
+		if !ok {
+			if s, isString := value.(string); isString {
+				var err error
+				if n, err = strconv.ParseFloat(s, 64); err == nil {
+					ok = true
+				}
+			}
+		}
+		if ok {
+			if valueType == "float" {
+				return float32(n), nil
+			}
+			return n, nil
+		}
+	case "int", "int64":
+		n, err := oscInteger(value)
+		if err != nil {
+			return nil, err
+		}
+		if valueType == "int64" {
+			return n, nil
+		}
+		// A wrapped uint64 could land in range, check the unsigned value
+		wrapped := v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64 && v.Uint() > math.MaxInt32
+		if wrapped || n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("%v is out of int range, use int64", value)
+		}
+		return int32(n), nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", valueType)
+	}
+	return nil, fmt.Errorf("can't send %T as %s", value, valueType)
+}
+
+// oscInteger converts integers, bools and rounded floats to an int64.
+// Unsigned values above MaxInt64 wrap to the same 64 bits.
+func oscInteger(value any) (int64, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// Bits are kept, a session UID above MaxInt64 arrives negative but
+		// still unique
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := math.Round(v.Float())
+		if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v is out of int64 range", value)
+		}
+		return int64(f), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.String:
+		n, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("can't send %q as an integer", v.String())
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("can't send %T as an integer", value)
+}
+
+// checkOSCValueType rejects types that can never be coerced from a field,
+// e.g. a name sent as int
+func checkOSCValueType(valueType string, path *oscPath) error {
+	if _, ok := oscValueTypes[valueType]; !ok {
+		return fmt.Errorf("unknown type %q", valueType)
+	}
+	if path.leaf == fixedNameType {
+		switch valueType {
+		case "int", "int64", "float", "double", "bool":
+			return fmt.Errorf("%s is a name, it can't be sent as %s", path.field, valueType)
+		}
+	}
+	return nil
+}
+
+// Latest coercion failure per mapping, reported by /api/osc/mappings and
+// logged once per distinct error
+var oscCoercionErrors struct {
+	sync.Mutex
+	byMapping map[string]string // destination + "/" + key -> error
+}
+
+func reportOSCCoercionError(b oscBinding, err error) {
+	id := b.destination + "/" + b.key
+	oscCoercionErrors.Lock()
+	defer oscCoercionErrors.Unlock()
+	if oscCoercionErrors.byMapping == nil {
+		oscCoercionErrors.byMapping = map[string]string{}
+	}
+	if oscCoercionErrors.byMapping[id] == err.Error() {
+		return
+	}
+	oscCoercionErrors.byMapping[id] = err.Error()
+	log.Printf("[warn] OSC mapping %s (%s) not sent: %v", id, b.entry.Address, err)
+}
+
+func oscCoercionError(destination, key string) string {
+	oscCoercionErrors.Lock()
+	defer oscCoercionErrors.Unlock()
+	return oscCoercionErrors.byMapping[destination+"/"+key]
+}
+
+func clearOSCCoercionErrors() {
+	oscCoercionErrors.Lock()
+	oscCoercionErrors.byMapping = nil
+	oscCoercionErrors.Unlock()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCoerceOSCValue(t *testing.T) {
+	tests := []struct {
+		valueType string
+		in        any
+		want      any
+	}{
+		// uint64 keeps its bits
+		{"", uint64(0), int64(0)},
+		{"", uint64(math.MaxInt64), int64(math.MaxInt64)},
+		{"", uint64(math.MaxInt64 + 1), int64(math.MinInt64)},
+		{"", uint64(math.MaxUint64), int64(-1)},
+		{"int64", uint64(math.MaxInt64), int64(math.MaxInt64)},
+		{"int64", uint64(math.MaxInt64 + 1), int64(math.MinInt64)},
+		{"int64", uint64(math.MaxUint64), int64(-1)},
+		{"string", uint64(math.MaxUint64), "18446744073709551615"},
+		// uint32 widens when it doesn't fit an int32
+		{"", uint32(math.MaxInt32), int32(math.MaxInt32)},
+		{"", uint32(math.MaxInt32 + 1), int64(math.MaxInt32 + 1)},
+		{"", uint32(math.MaxUint32), int64(math.MaxUint32)},
+		{"int64", uint32(math.MaxUint32), int64(math.MaxUint32)},
+		{"int", uint32(math.MaxInt32), int32(math.MaxInt32)},
+		// Small integers and floats
+		{"", uint8(200), int32(200)},
+		{"", int16(-3), int32(-3)},
+		{"", float64(0.5), float32(0.5)},
+		{"int", float32(2.6), int32(3)},
+		{"double", uint16(12000), float64(12000)},
+		{"float", "1.5", float32(1.5)},
+		// bool is sent as T/F
+		{"", true, true},
+		{"bool", uint8(1), true},
+		{"bool", uint8(0), false},
+		{"bool", float32(-0.2), true},
+		{"bool", "false", false},
+		{"int", true, int32(1)},
+		// blob, numbers little endian as in the UDP packets
+		{"blob", "abc", []byte("abc")},
+		{"blob", []byte{1, 2}, []byte{1, 2}},
+		{"blob", uint16(0x0102), []byte{0x02, 0x01}},
+		{"blob", uint64(math.MaxUint64), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"blob", float32(1), []byte{0x00, 0x00, 0x80, 0x3f}},
+	}
+	for _, tt := range tests {
+		got, err := coerceOSCValue(tt.valueType, tt.in)
+		if err != nil {
+			t.Errorf("%q %T(%v): %v", tt.valueType, tt.in, tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q %T(%v) = %T(%v), want %T(%v)", tt.valueType, tt.in, tt.in, got, got, tt.want, tt.want)
+		}
+	}
+}
+
+func TestCoerceOSCValueErrors(t *testing.T) {
+	tests := []struct {
+		valueType string
+		in        any
+	}{
+		{"int", uint32(math.MaxInt32 + 1)},
+		{"int", uint64(math.MaxUint64)},
+		{"int", "fast"},
+		{"int64", math.NaN()},
+		{"bool", "maybe"},
+		{"blob", int(1)},
+		{"float", []byte{1}},
+		{"uint", uint8(1)},
+	}
+	for _, tt := range tests {
+		if got, err := coerceOSCValue(tt.valueType, tt.in); err == nil {
+			t.Errorf("%q %T(%v) = %v, want an error", tt.valueType, tt.in, tt.in, got)
+		}
+	}
+}
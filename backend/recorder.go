@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture files (.f1cap) are append-only:
+//
+//	captureFileHeader
+//	repeated: captureRecordHeader followed by Length bytes of the raw datagram
+//
+// All values are little-endian. Record offsets are nanoseconds since the
+// recording started, taken from the monotonic clock.
+const (
+	captureMagic     = "F1CP"
+	captureVersion   = 1
+	captureExtension = ".f1cap"
+)
+
+type captureFileHeader struct {
+	Magic         [4]byte
+	Version       uint16
+	PacketFormat  uint16
+	GameYear      uint8
+	SessionUID    uint64
+	StartUnixNano int64
+}
+
+type captureRecordHeader struct {
+	Offset uint64
+	Length uint16
+}
+
+var recorder struct {
+	sync.Mutex
+	file          *os.File
+	w             *bufio.Writer
+	name          string
+	start         time.Time
+	headerWritten bool
+	packets       uint64
+	bytes         int64
+	err           string // why the last recording stopped by itself
+}
+
+func capturesDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(configDir, "f1-telem-bridge", "captures")
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// capturePath resolves a capture name inside the captures directory
+func capturePath(name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || !strings.HasSuffix(name, captureExtension) {
+		return "", fmt.Errorf("invalid capture name %q", name)
+	}
+	dir, err := capturesDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func startRecording() (string, error) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	if recorder.file != nil {
+		return recorder.name, errors.New("already recording")
+	}
+	name := "capture-" + time.Now().Format("20060102-150405") + captureExtension
+	path, err := capturePath(name)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	recorder.file = f
+	recorder.w = bufio.NewWriterSize(f, 64*1024)
+	recorder.name = name
+	recorder.start = time.Now()
+	recorder.headerWritten = false
+	recorder.packets = 0
+	recorder.bytes = 0
+	recorder.err = ""
+	log.Printf("[service] Recording UDP to %s", path)
+	return name, nil
+}
+
+func stopRecording() (string, error) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	if recorder.file == nil {
+		return "", errors.New("not recording")
+	}
+	name := recorder.name
+	err := recorder.w.Flush()
+	if cerr := recorder.file.Close(); err == nil {
+		err = cerr
+	}
+	recorder.file = nil
+	recorder.w = nil
+	log.Printf("[service] Recording %s stopped (%d packets)", name, recorder.packets)
+	return name, err
+}
+
+// recordDatagram appends a received datagram to the active capture. The
+// file header is written with the first full packet so it can carry the
+// packet format, game year and session UID.
+func recordDatagram(data []byte) {
+	recorder.Lock()
+	defer recorder.Unlock()
+	if recorder.file == nil {
+		return
+	}
+	if !recorder.headerWritten {
+		header, err := decodePacketHeader(data)
+		if err != nil {
+			return
+		}
+		fh := captureFileHeader{
+			Version:       captureVersion,
+			PacketFormat:  header.PacketFormat,
+			GameYear:      header.GameYear,
+			SessionUID:    header.SessionUID,
+			StartUnixNano: recorder.start.UnixNano(),
+		}
+		copy(fh.Magic[:], captureMagic)
+		if err := binary.Write(recorder.w, binary.LittleEndian, fh); err != nil {
+			abortRecording(err)
+			return
+		}
+		recorder.headerWritten = true
+	}
+	rh := captureRecordHeader{
+		Offset: uint64(time.Since(recorder.start)),
+		Length: uint16(len(data)),
+	}
+	if err := binary.Write(recorder.w, binary.LittleEndian, rh); err != nil {
+		abortRecording(err)
+		return
+	}
+	if _, err := recorder.w.Write(data); err != nil {
+		abortRecording(err)
+		return
+	}
+	recorder.packets++
+	recorder.bytes += int64(len(data))
+}
+
+// abortRecording stops a capture that can't be written, e.g. on a full disk.
+// What reached the file before the error is kept. Expects recorder to be
+// locked.
+func abortRecording(err error) {
+	log.Printf("[error] Could not write capture %s, recording stopped after %d packets: %v", recorder.name, recorder.packets, err)
+	recorder.file.Close()
+	recorder.file = nil
+	recorder.w = nil
+	recorder.err = err.Error()
+}
+
+func readCaptureHeader(r io.Reader) (captureFileHeader, error) {
+	var fh captureFileHeader
+	if err := binary.Read(r, binary.LittleEndian, &fh); err != nil {
+		return fh, err
+	}
+	if string(fh.Magic[:]) != captureMagic {
+		return fh, errors.New("not a capture file")
+	}
+	if fh.Version != captureVersion {
+		return fh, fmt.Errorf("unsupported capture version %d", fh.Version)
+	}
+	return fh, nil
+}
+
+type captureInfo struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	Modified     time.Time `json:"modified"`
+	PacketFormat uint16    `json:"packetFormat,omitempty"`
+	GameYear     uint8     `json:"gameYear,omitempty"`
+	SessionUID   string    `json:"sessionUID,omitempty"` // string, uint64 doesn't survive JavaScript
+	Started      time.Time `json:"started,omitzero"`
+}
+
+func listCaptures() ([]captureInfo, error) {
+	dir, err := capturesDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	captures := []captureInfo{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), captureExtension) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		c := captureInfo{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()}
+		if f, err := os.Open(filepath.Join(dir, e.Name())); err == nil {
+			if fh, err := readCaptureHeader(f); err == nil {
+				c.PacketFormat = fh.PacketFormat
+				c.GameYear = fh.GameYear
+				c.SessionUID = fmt.Sprint(fh.SessionUID)
+				c.Started = time.Unix(0, fh.StartUnixNano)
+			}
+			f.Close()
+		}
+		captures = append(captures, c)
+	}
+	sort.Slice(captures, func(i, j int) bool { return captures[i].Name > captures[j].Name })
+	return captures, nil
+}
+
+// REST API for packet recording:
+//
+//	GET  /api/recordings        recorder status and stored captures
+//	POST /api/recordings/start  start a new capture
+//	POST /api/recordings/stop   stop the active capture
+func handleRecordingsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] Recordings API handler crashed: %v", r)
+		}
+	}()
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/recordings"), "/")
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		captures, err := listCaptures()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recorder.Lock()
+		status := map[string]interface{}{
+			"recording": recorder.file != nil,
+			"current":   recorder.name,
+			"packets":   recorder.packets,
+			"bytes":     recorder.bytes,
+			"error":     recorder.err,
+			"captures":  captures,
+		}
+		recorder.Unlock()
+		json.NewEncoder(w).Encode(status)
+	case action == "start" && r.Method == http.MethodPost:
+		name, err := startRecording()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": name})
+	case action == "stop" && r.Method == http.MethodPost:
+		name, err := stopRecording()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": name})
+	default:
+		http.NotFound(w, r)
+	}
+}
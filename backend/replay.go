@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type replayRecord struct {
+	at     time.Duration // receive time relative to the start of the capture
+	offset int64         // file offset of the datagram
+	length uint16
+}
+
+// replayPlayer feeds a capture back through handleUDPPacket, honouring the
+// original inter-packet timing scaled by speed
+type replayPlayer struct {
+	sync.Mutex
+	name    string
+	file    *os.File
+	header  captureFileHeader
+	records []replayRecord
+	laps    map[int]int // player lap number -> first record index
+
+	pos     int
+	speed   float64
+	playing bool
+	closed  bool
+	gen     uint64        // bumped on every transport change
+	wake    chan struct{} // interrupts the playback wait
+
+	// Playback clock: record at baseMedia is due at baseWall
+	baseWall  time.Time
+	baseMedia time.Duration
+
+	emitAddr string
+	emitConn *net.UDPConn
+}
+
+var replayMu sync.Mutex
+var replayer *replayPlayer
+
+// A replay shares session tracking, laps, alerts and the session clock with
+// live traffic, interleaving both would flip between sessions. It can't be
+// played while UDP packets arrived within replayLiveWindow, and pauses
+// itself when they start arriving.
+const replayLiveWindow = 2 * time.Second
+
+var lastLivePacket atomic.Int64 // Unix nanoseconds, set by handleUDPPacket
+
+func liveTrafficArriving() bool {
+	at := lastLivePacket.Load()
+	return at != 0 && time.Since(time.Unix(0, at)) < replayLiveWindow
+}
+
+func openReplay(name string, emitAddr string) (*replayPlayer, error) {
+	path, err := capturePath(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &replayPlayer{name: name, file: f, speed: 1, laps: map[int]int{}, wake: make(chan struct{}, 1)}
+	if err := p.index(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if emitAddr != "" {
+		raddr, err := net.ResolveUDPAddr("udp", emitAddr)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if raddr.Port == Config.UDPPort && isLoopback(raddr.IP.String()) && isLoopback(Config.UDPAddr) {
+			f.Close()
+			return nil, errors.New("replay can't re-emit to the UDP listener itself")
+		}
+		if p.emitConn, err = net.DialUDP("udp", nil, raddr); err != nil {
+			f.Close()
+			return nil, err
+		}
+		p.emitAddr = emitAddr
+	}
+	go p.run()
+	return p, nil
+}
+
+// index reads the record table and the first record of every player lap
+func (p *replayPlayer) index() error {
+	r := bufio.NewReaderSize(p.file, 256*1024)
+	fh, err := readCaptureHeader(r)
+	if err != nil {
+		return err
+	}
+	p.header = fh
+	offset := int64(binary.Size(fh))
+	rhSize := int64(binary.Size(captureRecordHeader{}))
+	buf := make([]byte, 2048)
+	for {
+		var rh captureRecordHeader
+		if err := binary.Read(r, binary.LittleEndian, &rh); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break // a capture cut short by a crash is still playable
+			}
+			return err
+		}
+		if int(rh.Length) > len(buf) {
+			buf = make([]byte, rh.Length)
+		}
+		data := buf[:rh.Length]
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		offset += rhSize
+		if len(data) > 6 && data[6] == PacketLapData {
+			if pkt, err := decodeLapDataPacket(data); err == nil && pkt.Header.PlayerCarIndex < 22 {
+				lap := int(pkt.LapData[pkt.Header.PlayerCarIndex].CurrentLapNum)
+				if _, ok := p.laps[lap]; !ok {
+					p.laps[lap] = len(p.records)
+				}
+			}
+		}
+		p.records = append(p.records, replayRecord{at: time.Duration(rh.Offset), offset: offset, length: rh.Length})
+		offset += int64(rh.Length)
+	}
+	if len(p.records) == 0 {
+		return errors.New("capture contains no packets")
+	}
+	return nil
+}
+
+func (p *replayPlayer) run() {
+	buf := make([]byte, 2048)
+	for {
+		p.Lock()
+		if p.closed {
+			p.Unlock()
+			return
+		}
+		if p.pos >= len(p.records) {
+			p.playing = false
+		}
+		if !p.playing {
+			p.Unlock()
+			<-p.wake
+			continue
+		}
+		rec := p.records[p.pos]
+		gen := p.gen
+		due := p.baseWall.Add(time.Duration(float64(rec.at-p.baseMedia) / p.speed))
+		p.Unlock()
+
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-p.wake:
+				timer.Stop()
+				continue
+			}
+		}
+
+		p.Lock()
+		if p.gen != gen || p.closed {
+			p.Unlock()
+			continue
+		}
+		if liveTrafficArriving() {
+			p.playing = false
+			p.Unlock()
+			log.Printf("[warn] Replay %s paused, live UDP packets are arriving", p.name)
+			continue
+		}
+		if int(rec.length) > len(buf) {
+			buf = make([]byte, rec.length)
+		}
+		data := buf[:rec.length]
+		_, err := p.file.ReadAt(data, rec.offset)
+		p.pos++
+		emit := p.emitConn
+		p.Unlock()
+		if err != nil {
+			log.Printf("[error] Replay read failed: %v", err)
+			continue
+		}
+		handleUDPPacket(data, sourceReplay)
+		if emit != nil {
+			emit.Write(data)
+		}
+	}
+}
+
+// transport applies a change under the lock and restarts the playback clock
+func (p *replayPlayer) transport(change func()) {
+	p.Lock()
+	change()
+	p.gen++
+	if p.pos < len(p.records) {
+		p.baseMedia = p.records[p.pos].at
+	}
+	p.baseWall = time.Now()
+	p.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *replayPlayer) play() {
+	p.transport(func() {
+		if p.pos >= len(p.records) {
+			p.pos = 0 // start over once the end was reached
+		}
+		p.playing = true
+	})
+}
+
+func (p *replayPlayer) pause() { p.transport(func() { p.playing = false }) }
+
+func (p *replayPlayer) setSpeed(speed float64) error {
+	if speed <= 0 || speed > 100 {
+		return fmt.Errorf("invalid speed %v", speed)
+	}
+	p.transport(func() { p.speed = speed })
+	return nil
+}
+
+func (p *replayPlayer) seekLap(lap int) error {
+	idx, ok := p.laps[lap]
+	if !ok {
+		return fmt.Errorf("lap %d not found in capture", lap)
+	}
+	p.transport(func() { p.pos = idx })
+	return nil
+}
+
+func (p *replayPlayer) seekOffset(at time.Duration) {
+	idx := sort.Search(len(p.records), func(i int) bool { return p.records[i].at >= at })
+	p.transport(func() { p.pos = idx })
+}
+
+func (p *replayPlayer) close() {
+	p.transport(func() { p.closed = true })
+	p.file.Close()
+	if p.emitConn != nil {
+		p.emitConn.Close()
+	}
+}
+
+type replayStatus struct {
+	Loaded      bool    `json:"loaded"`
+	Name        string  `json:"name,omitempty"`
+	Playing     bool    `json:"playing"`
+	Speed       float64 `json:"speed,omitempty"`
+	Packet      int     `json:"packet"`
+	Packets     int     `json:"packets"`
+	PositionMS  int64   `json:"positionMs"`
+	DurationMS  int64   `json:"durationMs"`
+	Laps        []int   `json:"laps,omitempty"`
+	EmitAddr    string  `json:"emitAddr,omitempty"`
+	LiveTraffic bool    `json:"liveTraffic"` // play is refused while true
+}
+
+func (p *replayPlayer) status() replayStatus {
+	p.Lock()
+	defer p.Unlock()
+	s := replayStatus{
+		Loaded:      true,
+		Name:        p.name,
+		Playing:     p.playing,
+		Speed:       p.speed,
+		Packet:      p.pos,
+		Packets:     len(p.records),
+		DurationMS:  p.records[len(p.records)-1].at.Milliseconds(),
+		EmitAddr:    p.emitAddr,
+		LiveTraffic: liveTrafficArriving(),
+	}
+	if p.pos < len(p.records) {
+		s.PositionMS = p.records[p.pos].at.Milliseconds()
+	} else {
+		s.PositionMS = s.DurationMS
+	}
+	for lap := range p.laps {
+		s.Laps = append(s.Laps, lap)
+	}
+	sort.Ints(s.Laps)
+	return s
+}
+
+// REST API for replay transport controls:
+//
+//	GET  /api/replay         status
+//	POST /api/replay/load    {"name": "capture-....f1cap", "emitAddr": "127.0.0.1:20778"}
+//	POST /api/replay/play    409 while live packets are arriving
+//	POST /api/replay/pause
+//	POST /api/replay/speed   {"speed": 2}
+//	POST /api/replay/seek    {"lap": 5} or {"offsetMs": 60000}
+//	POST /api/replay/stop    unload the capture
+func handleReplayAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] Replay API handler crashed: %v", r)
+		}
+	}()
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/replay"), "/")
+	if (action == "" && r.Method != http.MethodGet) || (action != "" && r.Method != http.MethodPost) {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Name     string   `json:"name"`
+		EmitAddr string   `json:"emitAddr"`
+		Speed    float64  `json:"speed"`
+		Lap      *int     `json:"lap"`
+		OffsetMS *float64 `json:"offsetMs"`
+	}
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	if action == "load" {
+		p, err := openReplay(req.Name, req.EmitAddr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if replayer != nil {
+			replayer.close()
+		}
+		replayer = p
+		log.Printf("[service] Replay loaded %s (%d packets)", req.Name, len(p.records))
+	} else if action != "" && replayer == nil {
+		http.Error(w, "no capture loaded", http.StatusConflict)
+		return
+	}
+
+	var err error
+	switch action {
+	case "", "load":
+	case "play":
+		if liveTrafficArriving() {
+			http.Error(w, "live UDP packets are arriving, stop the game or simulator first", http.StatusConflict)
+			return
+		}
+		replayer.play()
+	case "pause":
+		replayer.pause()
+	case "speed":
+		err = replayer.setSpeed(req.Speed)
+	case "seek":
+		if req.Lap != nil {
+			err = replayer.seekLap(*req.Lap)
+		} else if req.OffsetMS != nil {
+			replayer.seekOffset(time.Duration(*req.OffsetMS * float64(time.Millisecond)))
+		} else {
+			err = errors.New("seek needs lap or offsetMs")
+		}
+	case "stop":
+		replayer.close()
+		replayer = nil
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if replayer == nil {
+		json.NewEncoder(w).Encode(replayStatus{})
+		return
+	}
+	json.NewEncoder(w).Encode(replayer.status())
+}
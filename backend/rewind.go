@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+)
+
+// A flashback rewinds the session: FrameIdentifier jumps back while
+// OverallFrameIdentifier keeps counting. The game also sends a FLBK event,
+// before or after the first rewound packet, so both are used and a rewind
+// is only handled once.
+
+// RewindEvent is published as Event/rewind
+type RewindEvent struct {
+	FromFrame       uint32
+	FromSessionTime float32
+	ToFrame         uint32
+	ToSessionTime   float32
+	Source          string // "frames" or "flashback"
+}
+
+// rewindHooks drop state derived from packets after the rewind point. They
+// run inside the packet handler, before the rewind event is published.
+var rewindHooks = []func(header PacketHeader, toSessionTime float32){
+	rollbackEventState,
+	rollbackLaps,
+	rollbackAlerts,
+}
+
+// A FLBK event within this many frames of a detected rewind is the same rewind
+const rewindDedupeFrames = 120
+
+var rewindState struct {
+	sessionUID  uint64
+	frame       uint32
+	overall     uint32
+	sessionTime float32
+	lastOverall uint32 // overall frame of the last handled rewind, 0 if none
+}
+
+// trackRewind runs for every packet and detects rewinds from the frame ids
+func trackRewind(header PacketHeader) {
+	if header.SessionUID == 0 {
+		return
+	}
+	if header.SessionUID != rewindState.sessionUID {
+		rewindState.sessionUID = header.SessionUID
+		rewindState.frame = header.FrameIdentifier
+		rewindState.overall = header.OverallFrameIdentifier
+		rewindState.sessionTime = header.SessionTime
+		rewindState.lastOverall = 0
+		return
+	}
+	if header.FrameIdentifier < rewindState.frame && header.OverallFrameIdentifier > rewindState.overall {
+		if !recentRewind(header) {
+			rewind(header, RewindEvent{
+				FromFrame:       rewindState.frame,
+				FromSessionTime: rewindState.sessionTime,
+				ToFrame:         header.FrameIdentifier,
+				ToSessionTime:   header.SessionTime,
+				Source:          "frames",
+			})
+		}
+	}
+	if header.OverallFrameIdentifier >= rewindState.overall {
+		rewindState.frame = header.FrameIdentifier
+		rewindState.overall = header.OverallFrameIdentifier
+		rewindState.sessionTime = header.SessionTime
+	}
+}
+
+// handleFlashbackEvent handles the FLBK event, which may arrive before the
+// frame ids go back
+func handleFlashbackEvent(header PacketHeader, flashback FlashbackEvent) {
+	if recentRewind(header) {
+		return
+	}
+	rewind(header, RewindEvent{
+		FromFrame:       rewindState.frame,
+		FromSessionTime: rewindState.sessionTime,
+		ToFrame:         flashback.FlashbackFrameIdentifier,
+		ToSessionTime:   flashback.FlashbackSessionTime,
+		Source:          "flashback",
+	})
+	// The following packets continue from the flashback frame
+	rewindState.frame = flashback.FlashbackFrameIdentifier
+	rewindState.sessionTime = flashback.FlashbackSessionTime
+}
+
+func recentRewind(header PacketHeader) bool {
+	return rewindState.lastOverall != 0 && header.OverallFrameIdentifier-rewindState.lastOverall < rewindDedupeFrames
+}
+
+func rewind(header PacketHeader, event RewindEvent) {
+	rewindState.lastOverall = header.OverallFrameIdentifier
+	log.Printf("[service] Rewind from frame %d (%.3fs) to frame %d (%.3fs), detected by %s",
+		event.FromFrame, event.FromSessionTime, event.ToFrame, event.ToSessionTime, event.Source)
+
+	// Values from before the rewind point have to be sent again
+	clear(lastSentWS)
+	clear(lastSentOSC)
+	for _, hook := range rewindHooks {
+		hook(header, event.ToSessionTime)
+	}
+	publishEvent(header, "rewind", event)
+}
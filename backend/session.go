@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sessions are keyed on PacketHeader.SessionUID. A session starts with the
+// first packet carrying a new UID and completes with FinalClassification or
+// the SEND event. A new UID that replaces an unfinished session on the same
+// track and session type is a restart.
+const maxSessionHistory = 200
+
+type SessionParticipant struct {
+	CarIndex     int    `json:"carIndex"`
+	Name         string `json:"name"`
+	TeamId       uint8  `json:"teamId"`
+	RaceNumber   uint8  `json:"raceNumber"`
+	AIControlled bool   `json:"aiControlled"`
+}
+
+type SessionRecord struct {
+	UID              string               `json:"uid"` // string, uint64 doesn't survive JavaScript
+	TrackId          int8                 `json:"trackId"`
+	SessionType      uint8                `json:"sessionType"`
+	Weather          uint8                `json:"weather"`
+	TrackTemperature int8                 `json:"trackTemperature"`
+	AirTemperature   int8                 `json:"airTemperature"`
+	TotalLaps        uint8                `json:"totalLaps"`
+	NetworkGame      bool                 `json:"networkGame"`
+	Participants     []SessionParticipant `json:"participants"`
+	Started          time.Time            `json:"started"`
+	Ended            time.Time            `json:"ended,omitzero"`
+	EndReason        string               `json:"endReason,omitempty"` // final_classification, session_ended, restarted, abandoned, interrupted
+	RestartOf        string               `json:"restartOf,omitempty"`
+	LastSessionTime  float32              `json:"lastSessionTime"`
+	Active           bool                 `json:"active"`
+
+	hasMetadata bool
+}
+
+// Lifecycle event details, published as Event/session_new,
+// Event/session_complete and Event/session_restart
+type SessionEvent struct {
+	UID         string
+	TrackId     int8
+	SessionType uint8
+	Reason      string
+}
+
+type SessionRestartEvent struct {
+	UID         string
+	PreviousUID string
+	TrackId     int8
+	SessionType uint8
+}
+
+var sessions struct {
+	sync.Mutex
+	list    []*SessionRecord // oldest first
+	current *SessionRecord
+
+	classified map[string]bool // sessions whose final classification was published
+}
+var sessionHistoryPath string
+
+func InitSessionHistory() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	appDir := filepath.Join(configDir, "f1-telem-bridge")
+	os.MkdirAll(appDir, 0755)
+	sessionHistoryPath = filepath.Join(appDir, "sessions.json")
+
+	if _, err := os.Stat(sessionHistoryPath); err == nil {
+		LoadSessionHistory()
+	}
+}
+
+// SaveSessionHistory expects sessions to be locked
+func SaveSessionHistory() {
+	f, err := os.Create(sessionHistoryPath)
+	if err != nil {
+		log.Printf("[error] Could not create session history file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(sessions.list); err != nil {
+		log.Printf("[error] Could not encode session history: %v", err)
+	}
+}
+
+func LoadSessionHistory() {
+	f, err := os.Open(sessionHistoryPath)
+	if err != nil {
+		log.Printf("[error] Could not open session history file: %v", err)
+		return
+	}
+	defer f.Close()
+	var list []*SessionRecord
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		log.Printf("[error] Could not decode session history: %v", err)
+		return
+	}
+	// Sessions still open when the bridge stopped can't be completed anymore
+	for _, s := range list {
+		if s.Active {
+			s.Active = false
+			s.EndReason = "interrupted"
+		}
+		s.hasMetadata = true
+	}
+	sessions.list = list
+}
+
+// trackSession runs for every packet and detects a new SessionUID
+func trackSession(header PacketHeader) {
+	if header.SessionUID == 0 {
+		return // lobby and menus
+	}
+	uid := fmt.Sprint(header.SessionUID)
+	sessions.Lock()
+	defer sessions.Unlock()
+	if sessions.current != nil && sessions.current.UID == uid {
+		sessions.current.LastSessionTime = header.SessionTime
+		return
+	}
+	if prev := sessions.current; prev != nil && prev.Active {
+		prev.Active = false
+		prev.Ended = time.Now()
+		prev.EndReason = "abandoned"
+	}
+	s := &SessionRecord{UID: uid, Started: time.Now(), LastSessionTime: header.SessionTime, Active: true}
+	sessions.current = s
+	sessions.list = append(sessions.list, s)
+	if len(sessions.list) > maxSessionHistory {
+		sessions.list = sessions.list[len(sessions.list)-maxSessionHistory:]
+	}
+	log.Printf("[service] New session %s", uid)
+	SaveSessionHistory()
+}
+
+// updateSessionFromSession fills the session metadata. The first Session
+// packet of a session publishes session_new, or session_restart when it
+// replaced an unfinished session on the same track and session type.
+func updateSessionFromSession(pkt PacketSessionData) {
+	sessions.Lock()
+	s := sessions.current
+	if s == nil || s.UID != fmt.Sprint(pkt.Header.SessionUID) {
+		sessions.Unlock()
+		return
+	}
+	first := !s.hasMetadata
+	s.hasMetadata = true
+	s.TrackId = pkt.TrackId
+	s.SessionType = pkt.SessionType
+	s.Weather = pkt.Weather
+	s.TrackTemperature = pkt.TrackTemperature
+	s.AirTemperature = pkt.AirTemperature
+	s.TotalLaps = pkt.TotalLaps
+	s.NetworkGame = pkt.NetworkGame == 1
+	if !first {
+		sessions.Unlock()
+		return
+	}
+	var prev *SessionRecord
+	if n := len(sessions.list); n > 1 {
+		prev = sessions.list[n-2]
+	}
+	restart := prev != nil && prev.EndReason == "abandoned" && prev.TrackId == s.TrackId && prev.SessionType == s.SessionType
+	if restart {
+		prev.EndReason = "restarted"
+		s.RestartOf = prev.UID
+	}
+	newEvent := SessionEvent{UID: s.UID, TrackId: s.TrackId, SessionType: s.SessionType}
+	restartEvent := SessionRestartEvent{UID: s.UID, PreviousUID: s.RestartOf, TrackId: s.TrackId, SessionType: s.SessionType}
+	SaveSessionHistory()
+	sessions.Unlock()
+
+	// Published without the lock, event handlers may look at sessions
+	if restart {
+		publishEvent(pkt.Header, "session_restart", restartEvent)
+	} else {
+		publishEvent(pkt.Header, "session_new", newEvent)
+	}
+}
+
+func updateSessionFromParticipants(pkt PacketParticipantsData) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	s := sessions.current
+	if s == nil || s.UID != fmt.Sprint(pkt.Header.SessionUID) {
+		return
+	}
+	first := s.Participants == nil
+	participants := make([]SessionParticipant, 0, pkt.NumActiveCars)
+	for i := 0; i < int(pkt.NumActiveCars) && i < len(pkt.Participants); i++ {
+		p := pkt.Participants[i]
+		participants = append(participants, SessionParticipant{
+			CarIndex:     i,
+			Name:         p.Name.String(),
+			TeamId:       p.TeamId,
+			RaceNumber:   p.RaceNumber,
+			AIControlled: p.AIControlled == 1,
+		})
+	}
+	s.Participants = participants
+	if first {
+		SaveSessionHistory()
+	}
+}
+
+// completeSession ends the current session once, reason is
+// final_classification or session_ended
+func completeSession(header PacketHeader, reason string) {
+	sessions.Lock()
+	s := sessions.current
+	if s == nil || !s.Active || s.UID != fmt.Sprint(header.SessionUID) {
+		sessions.Unlock()
+		return
+	}
+	s.Active = false
+	s.Ended = time.Now()
+	s.EndReason = reason
+	event := SessionEvent{UID: s.UID, TrackId: s.TrackId, SessionType: s.SessionType, Reason: reason}
+	SaveSessionHistory()
+	sessions.Unlock()
+	log.Printf("[service] Session %s complete (%s)", s.UID, reason)
+	laps.Lock()
+	commitLapRecords()
+	laps.Unlock()
+	publishEvent(header, "session_complete", event)
+}
+
+// FinalClassificationEvent is the race result, published as
+// Event/final_classification. Results are ordered by position.
+type FinalClassificationEvent struct {
+	UID     string
+	NumCars uint8
+	Results []ClassificationResult // WebSocket and webhooks only, not sent over OSC
+}
+
+type ClassificationResult struct {
+	Position      uint8
+	VehicleIdx    uint8
+	Name          string
+	TeamName      string
+	NumLaps       uint8
+	GridPosition  uint8
+	Points        uint8
+	NumPitStops   uint8
+	ResultStatus  uint8
+	BestLapTimeMS uint32
+	TotalRaceTime float64 // seconds, without penalties
+	PenaltiesTime uint8   // seconds
+}
+
+// publishFinalClassification publishes the result once per session, the
+// game repeats the packet and replays send it again
+func publishFinalClassification(pkt PacketFinalClassificationData) {
+	uid := fmt.Sprint(pkt.Header.SessionUID)
+	sessions.Lock()
+	if sessions.classified[uid] {
+		sessions.Unlock()
+		return
+	}
+	if sessions.classified == nil {
+		sessions.classified = map[string]bool{}
+	}
+	sessions.classified[uid] = true
+	sessions.Unlock()
+
+	event := FinalClassificationEvent{UID: uid, NumCars: pkt.NumCars, Results: []ClassificationResult{}}
+	liveState.RLock()
+	participants, hasParticipants := liveState.packets["Participants"].(PacketParticipantsData)
+	liveState.RUnlock()
+	for i, d := range pkt.ClassificationData {
+		if d.Position == 0 {
+			continue // unused slot
+		}
+		result := ClassificationResult{
+			Position:      d.Position,
+			VehicleIdx:    uint8(i),
+			NumLaps:       d.NumLaps,
+			GridPosition:  d.GridPosition,
+			Points:        d.Points,
+			NumPitStops:   d.NumPitStops,
+			ResultStatus:  d.ResultStatus,
+			BestLapTimeMS: d.BestLapTimeInMS,
+			TotalRaceTime: d.TotalRaceTime,
+			PenaltiesTime: d.PenaltiesTime,
+		}
+		if hasParticipants && participants.Header.SessionUID == pkt.Header.SessionUID {
+			result.Name = participants.Participants[i].Name.String()
+			result.TeamName = TeamNames[int(participants.Participants[i].TeamId)]
+		}
+		event.Results = append(event.Results, result)
+	}
+	sort.Slice(event.Results, func(a, b int) bool { return event.Results[a].Position < event.Results[b].Position })
+	publishEvent(pkt.Header, "final_classification", event)
+}
+
+// REST API for sessions:
+//
+//	GET /api/sessions          all sessions, newest first
+//	GET /api/sessions/current  the current session
+//	GET /api/sessions/{uid}
+func handleSessionsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] Sessions API handler crashed: %v", r)
+		}
+	}()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uid := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions"), "/")
+	sessions.Lock()
+	defer sessions.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if uid == "" {
+		list := make([]*SessionRecord, 0, len(sessions.list))
+		for i := len(sessions.list) - 1; i >= 0; i-- {
+			list = append(list, sessions.list[i])
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	if uid == "current" {
+		if sessions.current == nil {
+			http.Error(w, "no session yet", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(sessions.current)
+		return
+	}
+	for _, s := range sessions.list {
+		if s.UID == uid {
+			json.NewEncoder(w).Encode(s)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// initTestBridge loads every config from an empty config dir, as main does,
+// with OSC bundles sent to a local UDP socket
+func initTestBridge(t *testing.T) net.PacketConn {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(output) })
+
+	osc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { osc.Close() })
+
+	InitConfig()
+	Config.EnableOSC = true
+	Config.OSCBundles = true
+	Config.OSCAddr = "127.0.0.1"
+	Config.OSCPort = osc.LocalAddr().(*net.UDPAddr).Port
+	InitTelemetryFieldsConfig()
+	InitPacketForwardingConfig()
+	InitOSCAddressesConfig()
+	InitDerivedChannelsConfig()
+	InitAlertRulesConfig()
+	InitOSCDestinationsConfig()
+	InitWebhooksConfig()
+	InitForwardTargetsConfig()
+	InitSessionHistory()
+	InitLapRecords()
+	restartOSCService()
+	t.Cleanup(func() {
+		oscClientMu.Lock()
+		defer oscClientMu.Unlock()
+		for name, sender := range oscSenders {
+			sender.close()
+			delete(oscSenders, name)
+		}
+	})
+	return osc
+}
+
+func getTestAPI(t *testing.T, handler http.HandlerFunc, path string, v any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d: %s", path, rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+// TestSimulatedRace feeds a whole simulated race through handleUDPPacket
+// while the timing tower ticks and OSC restarts, as the UI does on a config
+// save. Run with -race.
+func TestSimulatedRace(t *testing.T) {
+	osc := initTestBridge(t)
+
+	var oscPackets atomic.Int64
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			if _, _, err := osc.ReadFrom(buf); err != nil {
+				return
+			}
+			oscPackets.Add(1)
+		}
+	}()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			publishTimingTower()
+			if i%50 == 0 {
+				restartOSCService()
+			}
+		}
+	}()
+
+	const cars, raceLaps = 3, 1
+	sim := newSimulator(simConfig{Seed: 1, Cars: cars, Laps: raceLaps, Rate: 10})
+	for steps := 0; !sim.done(); steps++ {
+		if steps > 100000 {
+			t.Fatal("simulation did not end")
+		}
+		for _, data := range sim.step() {
+			handleUDPPacket(data, sourceSimulated)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// Live state: every packet type and the session events
+	var state map[string]json.RawMessage
+	getTestAPI(t, handleStateAPI, "/api/state", &state)
+	for _, packet := range []string{"Session", "LapData", "Participants", "CarTelemetry", "FinalClassification", "Event"} {
+		if _, ok := state[packet]; !ok {
+			t.Errorf("/api/state has no %s", packet)
+		}
+	}
+	var events map[string]eventState
+	getTestAPI(t, handleStateAPI, "/api/state/event", &events)
+	for _, name := range []string{"session_started", "session_new", "lap_completed", "final_classification", "session_complete", "session_ended"} {
+		if _, ok := events[name]; !ok {
+			t.Errorf("no %s event", name)
+		}
+	}
+
+	var classification struct{ Results []ClassificationResult }
+	getTestAPI(t, handleStateAPI, "/api/state/event/final_classification/details", &classification)
+	if len(classification.Results) != cars {
+		t.Fatalf("final classification has %d results, want %d", len(classification.Results), cars)
+	}
+	for i, result := range classification.Results {
+		if int(result.Position) != i+1 || result.NumLaps != raceLaps || result.Name == "" {
+			t.Errorf("result %d = %+v", i, result)
+		}
+	}
+
+	// Laps: every car completed the race distance
+	var lapsResp lapsResponse
+	getTestAPI(t, handleLapsAPI, "/api/laps", &lapsResp)
+	if len(lapsResp.Cars) != cars {
+		t.Fatalf("/api/laps has %d cars, want %d", len(lapsResp.Cars), cars)
+	}
+	for _, car := range lapsResp.Cars {
+		if len(car.Laps) < raceLaps {
+			t.Errorf("car %d has %d laps, want %d", car.CarIndex, len(car.Laps), raceLaps)
+		}
+	}
+	if lapsResp.Bests.Lap == nil || lapsResp.Bests.Lap.TimeMS == 0 {
+		t.Errorf("no session best lap: %+v", lapsResp.Bests)
+	}
+
+	// Sessions: one race, completed by the final classification
+	var list []SessionRecord
+	getTestAPI(t, handleSessionsAPI, "/api/sessions", &list)
+	if len(list) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(list))
+	}
+	if s := list[0]; s.Active || s.EndReason != "final_classification" || len(s.Participants) != cars {
+		t.Errorf("session = %+v", s)
+	}
+
+	if oscPackets.Load() == 0 {
+		t.Error("no OSC packets received")
+	}
+}
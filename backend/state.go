@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Latest decoded value of every packet type, unthrottled and including
+// zeros, so new clients don't have to wait for the delta stream
+var liveState struct {
+	sync.RWMutex
+	packets map[string]any         // packet name -> latest packet
+	perCar  map[string]map[int]any // single-car packets (SessionHistory, TyreSets) by car index
+	events  map[string]eventState  // event name -> latest occurrence
+}
+
+type eventState struct {
+	Frame   uint32  `json:"frame"`
+	Time    float32 `json:"time"`
+	Details any     `json:"details"`
+}
+
+func storeState(packetName string, pkt any) {
+	liveState.Lock()
+	defer liveState.Unlock()
+	if car := packetCarIndex(pkt); car >= 0 {
+		if liveState.perCar == nil {
+			liveState.perCar = map[string]map[int]any{}
+		}
+		if liveState.perCar[packetName] == nil {
+			liveState.perCar[packetName] = map[int]any{}
+		}
+		liveState.perCar[packetName][car] = pkt
+		return
+	}
+	if liveState.packets == nil {
+		liveState.packets = map[string]any{}
+	}
+	liveState.packets[packetName] = pkt
+}
+
+func storeEventState(header PacketHeader, name string, details any) {
+	liveState.Lock()
+	defer liveState.Unlock()
+	if liveState.events == nil {
+		liveState.events = map[string]eventState{}
+	}
+	liveState.events[name] = eventState{Frame: header.FrameIdentifier, Time: header.SessionTime, Details: details}
+}
+
+// rollbackEventState forgets events that happened after a rewind point
+func rollbackEventState(header PacketHeader, toSessionTime float32) {
+	liveState.Lock()
+	defer liveState.Unlock()
+	for name, e := range liveState.events {
+		if e.Time > toSessionTime {
+			delete(liveState.events, name)
+		}
+	}
+}
+
+// stateSnapshot copies the store into one map keyed by packet name, with the
+// latest events under "Event"
+func stateSnapshot() map[string]any {
+	liveState.RLock()
+	defer liveState.RUnlock()
+	snapshot := make(map[string]any, len(liveState.packets)+len(liveState.perCar)+1)
+	for name, pkt := range liveState.packets {
+		snapshot[name] = pkt
+	}
+	for name, cars := range liveState.perCar {
+		byCar := make(map[int]any, len(cars))
+		for car, pkt := range cars {
+			byCar[car] = pkt
+		}
+		snapshot[name] = byCar
+	}
+	events := make(map[string]eventState, len(liveState.events))
+	for name, e := range liveState.events {
+		events[name] = e
+	}
+	snapshot["Event"] = events
+	return snapshot
+}
+
+// resolveStatePath walks a value by path segments. Segments match struct
+// fields and map keys case-insensitively and index arrays. A number on a
+// packet selects its per-car entry, so "lapdata/5" is LapData.LapData[5].
+func resolveStatePath(v reflect.Value, segments []string) (reflect.Value, error) {
+	for i, segment := range segments {
+		for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		next, ok := stateChild(v, segment)
+		if !ok {
+			return v, fmt.Errorf("%s not found", strings.Join(segments[:i+1], "/"))
+		}
+		v = next
+	}
+	return v, nil
+}
+
+func stateChild(v reflect.Value, segment string) (reflect.Value, bool) {
+	index, err := strconv.Atoi(segment)
+	isIndex := err == nil && index >= 0
+	switch v.Kind() {
+	case reflect.Struct:
+		if field := v.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, segment) }); field.IsValid() {
+			return field, true
+		}
+		if isIndex {
+			// The per-car array of the packet
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Field(i)
+				if field.Kind() == reflect.Array && field.Len() == 22 && field.Type().Elem().Kind() == reflect.Struct && index < 22 {
+					return field.Index(index), true
+				}
+			}
+		}
+	case reflect.Array, reflect.Slice:
+		if isIndex && index < v.Len() {
+			return v.Index(index), true
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if strings.EqualFold(fmt.Sprint(key.Interface()), segment) {
+				return v.MapIndex(key), true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// REST API for the live state:
+//
+//	GET /api/state                        every packet type and the latest events
+//	GET /api/state/{packet}[/{path}...]   e.g. /api/state/lapdata/5/carposition
+//
+// Enum ids get a "<Field>Label" with their display name next to them.
+func handleStateAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] State API handler crashed: %v", r)
+		}
+	}()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	snapshot := stateSnapshot()
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/state"), "/")
+	w.Header().Set("Content-Type", "application/json")
+	if path == "" {
+		json.NewEncoder(w).Encode(labelledValue(reflect.ValueOf(snapshot)))
+		return
+	}
+	v, err := resolveStatePath(reflect.ValueOf(snapshot), strings.Split(path, "/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(labelledValue(v))
+}
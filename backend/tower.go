@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The timing tower joins LapData, CarStatus, Participants and the lap
+// history into one table ordered by position. It is rebuilt and published
+// at Config.TimingTowerRateHz, only while new LapData arrives.
+const defaultTimingTowerRateHz = 4
+
+type TimingTowerRow struct {
+	Position     uint8  `json:"position"`
+	CarIndex     int    `json:"carIndex"`
+	Name         string `json:"name"`
+	TeamId       uint8  `json:"teamId"`
+	TeamName     string `json:"teamName"`
+	RaceNumber   uint8  `json:"raceNumber"`
+	GapMS        uint32 `json:"gapMS"`      // to the leader
+	IntervalMS   uint32 `json:"intervalMS"` // to the car ahead
+	CurrentLap   uint8  `json:"currentLap"`
+	LastLapMS    uint32 `json:"lastLapMS"`
+	BestLapMS    uint32 `json:"bestLapMS"` // best valid lap, 0 if none yet
+	PitStops     uint8  `json:"pitStops"`
+	PitStatus    uint8  `json:"pitStatus"`    // 0 = none, 1 = pitting, 2 = in pit area
+	TyreCompound uint8  `json:"tyreCompound"` // visual compound
+	Tyre         string `json:"tyre"`         // e.g. "Soft C3"
+	TyreAge      uint8  `json:"tyreAge"`      // laps
+	ResultStatus uint8  `json:"resultStatus"`
+}
+
+type TimingTower struct {
+	Frame uint32           `json:"frame"`
+	Time  float32          `json:"time"`
+	Rows  []TimingTowerRow `json:"rows"`
+}
+
+var timingTower struct {
+	sync.RWMutex
+	latest    TimingTower
+	lastFrame uint32
+}
+
+// buildTimingTower joins the latest packets, ok is false before the first
+// LapData of a session
+func buildTimingTower() (PacketHeader, TimingTower, bool) {
+	liveState.RLock()
+	lapData, ok := liveState.packets["LapData"].(LapDataPacket)
+	carStatus, hasStatus := liveState.packets["CarStatus"].(PacketCarStatusData)
+	participants, hasParticipants := liveState.packets["Participants"].(PacketParticipantsData)
+	liveState.RUnlock()
+	if !ok {
+		return PacketHeader{}, TimingTower{}, false
+	}
+
+	tower := TimingTower{Frame: lapData.Header.FrameIdentifier, Time: lapData.Header.SessionTime, Rows: []TimingTowerRow{}}
+	laps.Lock()
+	sameSession := laps.sessionUID == lapData.Header.SessionUID
+	for i, d := range lapData.LapData {
+		if d.CarPosition == 0 || d.ResultStatus < 2 {
+			continue // unused slot or inactive car
+		}
+		row := TimingTowerRow{
+			Position:     d.CarPosition,
+			CarIndex:     i,
+			CurrentLap:   d.CurrentLapNum,
+			LastLapMS:    d.LastLapTimeInMS,
+			PitStops:     d.NumPitStops,
+			PitStatus:    d.PitStatus,
+			ResultStatus: d.ResultStatus,
+		}
+		if d.CarPosition > 1 {
+			row.GapMS = uint32(d.DeltaToRaceLeaderMinutesPart)*60000 + uint32(d.DeltaToRaceLeaderMSPart)
+			row.IntervalMS = uint32(d.DeltaToCarInFrontMinutesPart)*60000 + uint32(d.DeltaToCarInFrontMSPart)
+		}
+		if sameSession && laps.cars[i].bests.Lap != nil {
+			row.BestLapMS = laps.cars[i].bests.Lap.TimeMS
+		}
+		if hasStatus && carStatus.Header.SessionUID == lapData.Header.SessionUID {
+			row.TyreCompound = carStatus.CarStatusData[i].VisualTyreCompound
+			row.TyreAge = carStatus.CarStatusData[i].TyresAgeLaps
+			row.Tyre = tyreLabel(carStatus.CarStatusData[i].ActualTyreCompound, row.TyreCompound)
+		}
+		if hasParticipants && participants.Header.SessionUID == lapData.Header.SessionUID {
+			p := participants.Participants[i]
+			row.Name = p.Name.String()
+			row.TeamId = p.TeamId
+			row.TeamName = TeamNames[int(p.TeamId)]
+			row.RaceNumber = p.RaceNumber
+		}
+		tower.Rows = append(tower.Rows, row)
+	}
+	laps.Unlock()
+	sort.Slice(tower.Rows, func(a, b int) bool { return tower.Rows[a].Position < tower.Rows[b].Position })
+	return lapData.Header, tower, true
+}
+
+func timingTowerInterval() time.Duration {
+	rate := Config.TimingTowerRateHz
+	if rate <= 0 {
+		rate = defaultTimingTowerRateHz
+	}
+	return time.Second / time.Duration(rate)
+}
+
+// runTimingTower publishes the timing tower at the configured rate
+func runTimingTower() {
+	interval := timingTowerInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if next := timingTowerInterval(); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+		publishTimingTower()
+	}
+}
+
+func publishTimingTower() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] Timing tower crashed: %v", r)
+		}
+	}()
+	// OSC frames and the last sent values are shared with packet handling
+	packetHandlerMu.Lock()
+	defer packetHandlerMu.Unlock()
+	header, tower, ok := buildTimingTower()
+	if !ok {
+		return
+	}
+	timingTower.Lock()
+	if header.FrameIdentifier == timingTower.lastFrame && len(timingTower.latest.Rows) > 0 {
+		timingTower.Unlock()
+		return // no new LapData since the last tick
+	}
+	timingTower.lastFrame = header.FrameIdentifier
+	timingTower.latest = tower
+	timingTower.Unlock()
+
+	publishWS(header, "TimingTower", -1, tower)
+
+	// One OSC message per row, in position order
+	frame := newOSCFrame(header, "TimingTower")
+	for _, row := range tower.Rows {
+		frame.sendEvent("TimingTower_Row", row.Position, int32(row.CarIndex), row.Name, row.GapMS, row.IntervalMS,
+			row.LastLapMS, row.BestLapMS, row.PitStops, row.TyreCompound)
+	}
+	frame.flush()
+}
+
+// REST API for the latest timing tower
+func handleTimingTowerAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] TimingTower API handler crashed: %v", r)
+		}
+	}()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	timingTower.RLock()
+	tower := timingTower.latest
+	timingTower.RUnlock()
+	if tower.Rows == nil {
+		tower.Rows = []TimingTowerRow{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tower)
+}
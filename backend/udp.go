@@ -3,12 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -78,7 +81,7 @@ func StartUDPListener() {
 		} else {
 			log.Printf("[raw] %s | unknown | from %s: %x", time.Now().Format("15:04:05.000"), addr, buf[:n])
 		}
-		handleUDPPacket(buf[:n])
+		handleUDPPacket(buf[:n], sourceLive)
 	}
 }
 
@@ -102,6 +105,14 @@ type CarTelemetryData struct {
 	SurfaceType             [4]uint8   // m_surfaceType
 }
 
+type PacketCarTelemetryData struct {
+	Header                       PacketHeader
+	CarTelemetryData             [22]CarTelemetryData
+	MFDPanelIndex                uint8 // 255 = MFD closed
+	MFDPanelIndexSecondaryPlayer uint8
+	SuggestedGear                int8 // 0 if no gear suggested
+}
+
 // -------------------- F1 25 UDP Packet Structs --------------------
 // These structs match the official F1 25 UDP spec for all packet types
 
@@ -274,7 +285,7 @@ type LapDataPacket struct {
 type PacketEventData struct {
 	Header          PacketHeader
 	EventStringCode [4]uint8
-	EventDetails    [24]byte // Union, handled per event type
+	EventDetails    [12]byte // Union, decoded per event type by decodeEventDetails
 }
 
 type LiveryColour struct {
@@ -283,6 +294,25 @@ type LiveryColour struct {
 	Blue  uint8
 }
 
+// FixedName is a fixed-size, null-terminated UTF-8 name. It is published as
+// a trimmed string everywhere instead of 32 bytes.
+type FixedName [32]byte
+
+func (n FixedName) String() string {
+	return strings.ToValidUTF8(cString(n[:]), "")
+}
+
+func (n FixedName) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.String())
+}
+
+// Shown instead of the name of a human player with ShowOnlineNames off
+func hiddenName(raceNumber uint8) FixedName {
+	var n FixedName
+	copy(n[:], fmt.Sprintf("Driver #%d", raceNumber))
+	return n
+}
+
 type ParticipantData struct {
 	AIControlled    uint8
 	DriverId        uint8
@@ -291,7 +321,7 @@ type ParticipantData struct {
 	MyTeam          uint8
 	RaceNumber      uint8
 	Nationality     uint8
-	Name            [32]byte
+	Name            FixedName
 	YourTelemetry   uint8
 	ShowOnlineNames uint8
 	TechLevel       uint16
@@ -400,7 +430,7 @@ type LobbyInfoData struct {
 	TeamId          uint8
 	Nationality     uint8
 	Platform        uint8
-	Name            [32]byte
+	Name            FixedName
 	CarNumber       uint8
 	YourTelemetry   uint8
 	ShowOnlineNames uint8
@@ -594,23 +624,71 @@ var PacketNames = map[uint8]string{
 }
 
 // Helper for decode, marshal, broadcast, and OSC forward
-func decodeAndBroadcast[T any](data []byte, decodeFunc func([]byte) (T, error), packetName string, packetID uint8) {
+func decodeAndBroadcast[T any](header PacketHeader, data []byte, decodeFunc func([]byte) (T, error), packetName string, packetID uint8) {
 	pkt, err := decodeFunc(data)
 	if err != nil {
 		log.Printf("[error] decode %s: %v", packetName, err)
 		return
 	}
-	// Broadcast each field as "PacketName/FieldName value"
+	// Publish each field as topic "PacketName/FieldName"
 	v := reflect.ValueOf(pkt)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
 	if v.Kind() == reflect.Struct {
-		broadcastStructFieldsToWS(v, packetName)
-		sendStructFieldsToOSC(v)
+		handlePacket(header, packetName, v, func(frame *oscFrame) {
+			broadcastStructFieldsToWS(v, packetName, header, packetCarIndex(pkt))
+		})
 	}
 }
 
+// handlePacket runs a decoded packet through the steps every packet type
+// shares: state, WebSocket (publish), OSC mappings, derived channels and
+// alerts. All OSC output of the packet goes out in one frame.
+func handlePacket(header PacketHeader, packetName string, v reflect.Value, publish func(frame *oscFrame)) {
+	pkt := v.Interface()
+	trackPacket(packetName, pkt)
+	frame := newOSCFrame(header, packetName)
+	publish(frame)
+	sendPacketToOSC(packetName, v, packetCarIndex(pkt), frame)
+	updateDerivedChannels(packetName, frame)
+	updateAlerts(packetName, frame)
+	frame.flush()
+}
+
+// trackPacket feeds decoded packets to the state derived from them
+func trackPacket(packetName string, pkt any) {
+	storeState(packetName, pkt)
+	switch p := pkt.(type) {
+	case PacketSessionData:
+		updateFocusFromSession(p)
+		updateSessionFromSession(p)
+		updateLapsFromSession(p)
+	case PacketParticipantsData:
+		updateFocusFromParticipants(p)
+		updateSessionFromParticipants(p)
+	case LapDataPacket:
+		updateLapsFromLapData(p)
+	case PacketCarTelemetryData:
+		updateLapsFromTelemetry(p)
+	case PacketFinalClassificationData:
+		publishFinalClassification(p)
+		completeSession(p.Header, "final_classification")
+	}
+}
+
+// packetCarIndex returns the car a single-car packet (SessionHistory,
+// TyreSets) belongs to, or -1 for packets that cover every car
+func packetCarIndex(pkt any) int {
+	switch p := pkt.(type) {
+	case PacketSessionHistoryData:
+		return int(p.CarIdx)
+	case PacketTyreSetsData:
+		return int(p.CarIdx)
+	}
+	return -1
+}
+
 var lastWebSocketBroadcast time.Time
 
 // Throttle and deduplicate for WebSocket and OSC
@@ -654,45 +732,47 @@ func valuesEqual(a, b interface{}) bool {
 	}
 }
 
-func shouldSend(key string, value interface{}, lastSent map[string]struct {
-	t time.Time
-	v interface{}
-}) bool {
-	// Ignore zero values for float32/float64/int types
+// isSuppressedZero reports zero values that are never sent unless an OSC
+// mapping explicitly allows them
+func isSuppressedZero(value interface{}) bool {
 	switch v := value.(type) {
 	case float32:
-		if v == 0 {
-			return false
-		}
+		return v == 0
 	case float64:
-		if v == 0 {
-			return false
-		}
+		return v == 0
 	case int:
-		if v == 0 {
-			return false
-		}
+		return v == 0
 	case int32:
-		if v == 0 {
-			return false
-		}
+		return v == 0
 	case int64:
-		if v == 0 {
-			return false
-		}
+		return v == 0
 	case uint:
-		if v == 0 {
-			return false
-		}
+		return v == 0
 	case uint32:
-		if v == 0 {
-			return false
-		}
+		return v == 0
 	case uint64:
-		if v == 0 {
-			return false
-		}
+		return v == 0
 	}
+	return false
+}
+
+func shouldSend(key string, value interface{}, lastSent map[string]struct {
+	t time.Time
+	v interface{}
+}) bool {
+	// Ignore zero values for float32/float64/int types
+	if isSuppressedZero(value) {
+		return false
+	}
+	return changedSince(key, value, lastSent)
+}
+
+// changedSince reports whether value differs from the last one sent for key,
+// at most once per broadcast interval
+func changedSince(key string, value interface{}, lastSent map[string]struct {
+	t time.Time
+	v interface{}
+}) bool {
 	entry, ok := lastSent[key]
 	now := time.Now()
 	interval := getBroadcastInterval()
@@ -722,26 +802,44 @@ func updateLastSent(key string, value interface{}, lastSent map[string]struct {
 	}{time.Now(), value}
 }
 
-func broadcastStructFieldsToWS(v reflect.Value, packetName string) {
+// broadcastStructFieldsToWS publishes every field as topic "PacketName/FieldName".
+// car is the car the fields belong to, -1 if they aren't car specific.
+func broadcastStructFieldsToWS(v reflect.Value, packetName string, header PacketHeader, car int) {
 	typeOfV := v.Type()
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
 		fieldType := typeOfV.Field(i)
 		name := fieldType.Name
+		if fixed, ok := field.Interface().(FixedName); ok {
+			key := fmt.Sprintf("%s/%s", packetName, name)
+			if shouldSend(key, fixed.String(), lastSentWS) {
+				publishWS(header, key, car, fixed.String())
+				updateLastSent(key, fixed.String(), lastSentWS)
+			}
+			continue
+		}
 		if field.Kind() == reflect.Struct {
-			broadcastStructFieldsToWS(field, packetName+"/"+name)
+			broadcastStructFieldsToWS(field, packetName+"/"+name, header, car)
 			continue
 		}
 		if field.Kind() == reflect.Array || field.Kind() == reflect.Slice {
 			for j := 0; j < field.Len(); j++ {
 				elem := field.Index(j)
+				if elem.Kind() == reflect.Struct {
+					// Per-car arrays are keyed by car index, e.g. CarTelemetry/3/Speed
+					if field.Len() == 22 {
+						broadcastStructFieldsToWS(elem, fmt.Sprintf("%s/%d", packetName, j), header, j)
+					} else {
+						broadcastStructFieldsToWS(elem, fmt.Sprintf("%s/%s[%d]", packetName, name, j), header, car)
+					}
+					continue
+				}
 				key := fmt.Sprintf("%s/%s[%d]", packetName, name, j)
 				if Config.DebugOutput {
 					log.Printf("[debug] WebSocket key: %s value: %v", key, elem.Interface())
 				}
 				if shouldSend(key, elem.Interface(), lastSentWS) {
-					msg := fmt.Sprintf("%s %v", key, elem.Interface())
-					broadcast([]byte(msg))
+					publishWS(header, key, car, elem.Interface())
 					updateLastSent(key, elem.Interface(), lastSentWS)
 				}
 			}
@@ -749,169 +847,174 @@ func broadcastStructFieldsToWS(v reflect.Value, packetName string) {
 		}
 		key := fmt.Sprintf("%s/%s", packetName, name)
 		if shouldSend(key, field.Interface(), lastSentWS) {
-			msg := fmt.Sprintf("%s %v", key, field.Interface())
-			broadcast([]byte(msg))
+			publishWS(header, key, car, field.Interface())
 			updateLastSent(key, field.Interface(), lastSentWS)
 		}
 	}
 }
 
-func sendStructFieldsToOSC(v reflect.Value) {
-	typeOfV := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		fieldType := typeOfV.Field(i)
-		name := fieldType.Name
-		if entry, ok := OSCAddresses[name]; ok && entry.Enabled && Config.EnableOSC {
-			key := entry.Address
-			// Only send 0 if AllowZero is true for this address
-			sendZero := false
-			switch v := field.Interface().(type) {
-			case float32, float64, int, int32, int64, uint, uint32, uint64:
-				if v == 0 && !entry.AllowZero {
-					continue
-				}
-				if v == 0 && entry.AllowZero {
-					sendZero = true
-				}
-			}
-			if shouldSend(key, field.Interface(), lastSentOSC) || sendZero {
-				sendOSC(entry.Address, field.Interface())
-				updateLastSent(key, field.Interface(), lastSentOSC)
-			}
-		}
-		if field.Kind() == reflect.Struct {
-			sendStructFieldsToOSC(field)
-		}
-		if field.Kind() == reflect.Array || field.Kind() == reflect.Slice {
-			// Suffixes for 4-wheel arrays
-			suffixes := []string{"RL", "RR", "FL", "FR"}
-			for j := 0; j < field.Len(); j++ {
-				elem := field.Index(j)
-				key := name
-				if field.Len() == 4 && j < len(suffixes) {
-					key = name + suffixes[j]
-				}
-				if entry, ok := OSCAddresses[key]; ok && entry.Enabled && Config.EnableOSC {
-					oscKey := entry.Address
-					if shouldSend(oscKey, elem.Interface(), lastSentOSC) {
-						sendOSC(entry.Address, elem.Interface())
-						updateLastSent(oscKey, elem.Interface(), lastSentOSC)
-					}
-				}
-				if elem.Kind() == reflect.Struct {
-					sendStructFieldsToOSC(elem)
-				}
-			}
-		}
-	}
+// telemetrySummary is the value of the CarTelemetry/summary topic
+type telemetrySummary struct {
+	Speed    uint16
+	Throttle float32
+	Steer    float32
+	Brake    float32
+	Clutch   uint8
+	Gear     int8
+	RPM      uint16
 }
 
-func broadcastTelemetryFields(telemetry CarTelemetryData) {
-	msg := fmt.Sprintf(
-		"CarTelemetry/Speed %d | Throttle %.2f | Steer %.2f | Brake %.2f | Clutch %d | Gear %d | RPM %d",
-		telemetry.Speed,
-		telemetry.Throttle,
-		telemetry.Steer,
-		telemetry.Brake,
-		telemetry.Clutch,
-		telemetry.Gear,
-		telemetry.RPM,
-	)
-	key := "CarTelemetry/summary"
-	if shouldSend(key, msg, lastSentWS) {
-		broadcast([]byte(msg))
-		updateLastSent(key, msg, lastSentWS)
-	}
-	fields := map[string]interface{}{
-		"Speed":     telemetry.Speed,
-		"Throttle":  telemetry.Throttle,
-		"Steer":     telemetry.Steer,
-		"Brake":     telemetry.Brake,
-		"Clutch":    telemetry.Clutch,
-		"Gear":      telemetry.Gear,
-		"EngineRPM": telemetry.EngineRPM, // Use EngineRPM as the key, not RPM
+func broadcastTelemetrySummary(telemetry CarTelemetryData, frame *oscFrame) {
+	summary := telemetrySummary{
+		Speed:    telemetry.Speed,
+		Throttle: telemetry.Throttle,
+		Steer:    telemetry.Steer,
+		Brake:    telemetry.Brake,
+		Clutch:   telemetry.Clutch,
+		Gear:     telemetry.Gear,
+		RPM:      telemetry.RPM,
 	}
-	for k, v := range fields {
-		if entry, ok := OSCAddresses[k]; ok && entry.Enabled && Config.EnableOSC {
-			oscKey := entry.Address
-			if shouldSend(oscKey, v, lastSentOSC) {
-				sendOSC(entry.Address, v)
-				updateLastSent(oscKey, v, lastSentOSC)
-			}
-		}
+	key := "CarTelemetry/summary"
+	if shouldSend(key, summary, lastSentWS) {
+		publishWS(frame.header, key, frame.focusCar, summary)
+		updateLastSent(key, summary, lastSentWS)
 	}
 }
 
+// Serializes packet handling, packets arrive from the UDP listener and from replays
+var packetHandlerMu sync.Mutex
+
+// packetSource tells where the packet being handled came from. Only live
+// packets are posted to webhooks, a replay or a simulation would otherwise
+// re-post old or made-up events.
+type packetSource int
+
+const (
+	sourceLive packetSource = iota
+	sourceReplay
+	sourceSimulated
+)
+
+// Source of the packet being handled, guarded by packetHandlerMu. It is
+// sourceLive outside handleUDPPacket, e.g. for events from the API.
+var currentPacketSource = sourceLive
+
 // Main UDP handler dispatches based on PacketId
-func handleUDPPacket(data []byte) {
+func handleUDPPacket(data []byte, source packetSource) {
 	if len(data) < 24 {
 		return
 	}
+	if source == sourceLive {
+		lastLivePacket.Store(time.Now().UnixNano())
+	}
+	packetHandlerMu.Lock()
+	defer packetHandlerMu.Unlock()
 	packetID := data[6] // FIX: packetId is at offset 6 per F1 25 spec
 	if !PacketForwardingConfig[packetID] {
 		return // Not enabled, skip processing
 	}
+	header, err := decodePacketHeader(data)
+	if err != nil {
+		log.Printf("[error] decode header: %v", err)
+		return
+	}
+	if header.GameMajorVersion == simGameMajorVersion {
+		source = sourceSimulated
+	}
+	currentPacketSource = source
+	defer func() { currentPacketSource = sourceLive }()
+	trackSession(header)
+	trackRewind(header)
 
 	switch packetID {
 	case PacketMotion:
-		decodeAndBroadcast(data, decodeMotionPacket, "Motion", PacketMotion)
+		decodeAndBroadcast(header, data, decodeMotionPacket, "Motion", PacketMotion)
 	case PacketSession:
-		decodeAndBroadcast(data, decodeSessionPacket, "Session", PacketSession)
+		decodeAndBroadcast(header, data, decodeSessionPacket, "Session", PacketSession)
 	case PacketLapData:
-		decodeAndBroadcast(data, decodeLapDataPacket, "LapData", PacketLapData)
+		decodeAndBroadcast(header, data, decodeLapDataPacket, "LapData", PacketLapData)
 	case PacketEvent:
-		decodeAndBroadcast(data, decodeEventPacket, "Event", PacketEvent)
+		handleEventPacket(header, data)
 	case PacketParticipants:
-		decodeAndBroadcast(data, decodeParticipantsPacket, "Participants", PacketParticipants)
+		decodeAndBroadcast(header, data, decodeParticipantsPacket, "Participants", PacketParticipants)
 	case PacketCarSetups:
-		decodeAndBroadcast(data, decodeCarSetupsPacket, "CarSetups", PacketCarSetups)
+		decodeAndBroadcast(header, data, decodeCarSetupsPacket, "CarSetups", PacketCarSetups)
 	case PacketCarTelemetry:
-		telemetry := decodeCarTelemetryPacket(data)
-		broadcastTelemetryFields(telemetry)
+		pkt, err := decodeCarTelemetryPacket(data)
+		if err != nil {
+			return
+		}
+		v := reflect.ValueOf(pkt)
+		handlePacket(header, "CarTelemetry", v, func(frame *oscFrame) {
+			broadcastStructFieldsToWS(v, "CarTelemetry", header, -1)
+			// The single-car summary follows the focus car
+			if frame.focusCar >= 0 {
+				broadcastTelemetrySummary(pkt.CarTelemetryData[frame.focusCar], frame)
+			}
+		})
 	case PacketCarStatus:
-		decodeAndBroadcast(data, decodeCarStatusPacket, "CarStatus", PacketCarStatus)
+		decodeAndBroadcast(header, data, decodeCarStatusPacket, "CarStatus", PacketCarStatus)
 	case PacketFinalClassification:
-		decodeAndBroadcast(data, decodeFinalClassificationPacket, "FinalClassification", PacketFinalClassification)
+		decodeAndBroadcast(header, data, decodeFinalClassificationPacket, "FinalClassification", PacketFinalClassification)
 	case PacketLobbyInfo:
-		decodeAndBroadcast(data, decodeLobbyInfoPacket, "LobbyInfo", PacketLobbyInfo)
+		decodeAndBroadcast(header, data, decodeLobbyInfoPacket, "LobbyInfo", PacketLobbyInfo)
 	case PacketCarDamage:
-		decodeAndBroadcast(data, decodeCarDamagePacket, "CarDamage", PacketCarDamage)
+		decodeAndBroadcast(header, data, decodeCarDamagePacket, "CarDamage", PacketCarDamage)
 	case PacketSessionHistory:
-		decodeAndBroadcast(data, decodeSessionHistoryPacket, "SessionHistory", PacketSessionHistory)
+		decodeAndBroadcast(header, data, decodeSessionHistoryPacket, "SessionHistory", PacketSessionHistory)
 	case PacketTyreSets:
-		decodeAndBroadcast(data, decodeTyreSetsPacket, "TyreSets", PacketTyreSets)
+		decodeAndBroadcast(header, data, decodeTyreSetsPacket, "TyreSets", PacketTyreSets)
 	case PacketMotionEx:
 		pkt, err := decodeMotionExPacket(data)
 		if err != nil {
 			log.Printf("[error] decodeMotionExPacket: %v", err)
 			return
 		}
-		broadcastMotionExFields(pkt)
-		// No JSON or forwardJSONToOSC here
+		handlePacket(header, "MotionEx", reflect.ValueOf(pkt), func(frame *oscFrame) {
+			broadcastMotionExFields(pkt, frame)
+		})
 	case PacketTimeTrial:
-		decodeAndBroadcast(data, decodeTimeTrialPacket, "TimeTrial", PacketTimeTrial)
+		decodeAndBroadcast(header, data, decodeTimeTrialPacket, "TimeTrial", PacketTimeTrial)
 	case PacketLapPositions:
-		decodeAndBroadcast(data, decodeLapPositionsPacket, "LapPositions", PacketLapPositions)
+		decodeAndBroadcast(header, data, decodeLapPositionsPacket, "LapPositions", PacketLapPositions)
 	}
 }
 
-func decodeCarTelemetryPacket(data []byte) CarTelemetryData {
-	// Per F1 25 spec, header is 29 bytes, then 22 cars * 60 bytes each = 1352 bytes
-	// Player car index is at offset 27 (m_playerCarIndex in header)
-	if len(data) < 29+22*60 {
-		log.Printf("[error] decodeCarTelemetryPacket: data too short (len=%d, need=%d)", len(data), 29+22*60)
-		return CarTelemetryData{}
+func decodePacketHeader(data []byte) (PacketHeader, error) {
+	var header PacketHeader
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header)
+	return header, err
+}
+
+func decodeCarTelemetryPacket(data []byte) (PacketCarTelemetryData, error) {
+	// Per F1 25 spec, header is 29 bytes, then 22 cars * 60 bytes each = 1320 bytes,
+	// then MFD panel indexes and suggested gear = 1352 bytes
+	const expectedSize = 29 + 22*60 + 3
+	var pkt PacketCarTelemetryData
+	if len(data) < expectedSize {
+		log.Printf("[error] decodeCarTelemetryPacket: data too short (len=%d, need=%d)", len(data), expectedSize)
+		return pkt, io.ErrUnexpectedEOF
 	}
-	carIndex := int(data[27]) // m_playerCarIndex
-	if carIndex < 0 || carIndex >= 22 {
-		log.Printf("[error] decodeCarTelemetryPacket: invalid car index %d", carIndex)
-		return CarTelemetryData{}
+	header, err := decodePacketHeader(data)
+	if err != nil {
+		return pkt, err
 	}
+	pkt.Header = header
 	// Car telemetry data starts at offset 29
-	carDataStart := 29 + carIndex*60
-	carData := data[carDataStart : carDataStart+60]
+	for i := range pkt.CarTelemetryData {
+		carDataStart := 29 + i*60
+		pkt.CarTelemetryData[i] = decodeCarTelemetryData(data[carDataStart : carDataStart+60])
+	}
+	trailer := data[29+22*60:]
+	pkt.MFDPanelIndex = trailer[0]
+	pkt.MFDPanelIndexSecondaryPlayer = trailer[1]
+	pkt.SuggestedGear = int8(trailer[2])
+	return pkt, nil
+}
 
+// decodeCarTelemetryData decodes one 60 byte CarTelemetryData entry. It is
+// decoded by hand because of the extra RPM compatibility field.
+func decodeCarTelemetryData(carData []byte) CarTelemetryData {
 	telemetry := CarTelemetryData{}
 	telemetry.Speed = binary.LittleEndian.Uint16(carData[0:2])
 	telemetry.Throttle = mathFromBits(carData[2:6])
@@ -920,6 +1023,7 @@ func decodeCarTelemetryPacket(data []byte) CarTelemetryData {
 	telemetry.Clutch = carData[14]
 	telemetry.Gear = int8(carData[15])
 	telemetry.EngineRPM = binary.LittleEndian.Uint16(carData[16:18])
+	telemetry.RPM = telemetry.EngineRPM
 	telemetry.DRS = carData[18]
 	telemetry.RevLightsPercent = carData[19]
 	telemetry.RevLightsBitValue = binary.LittleEndian.Uint16(carData[20:22])
@@ -990,8 +1094,8 @@ func decodeEventPacket(data []byte) (PacketEventData, error) {
 		if time.Now().Unix()-lastEventShortLog > 10 {
 			// Add packet id and event string code to the error log
 			var eventCode string
-			if len(data) >= 11 {
-				eventCode = string(data[9:13])
+			if len(data) >= 33 {
+				eventCode = string(data[29:33])
 			}
 			log.Printf("[error] decode Event: unexpected EOF | packetID=%d eventCode=%q (got %d, want %d)", PacketEvent, eventCode, len(data), expectedSize)
 			lastEventShortLog = time.Now().Unix()
@@ -1012,6 +1116,11 @@ func decodeParticipantsPacket(data []byte) (PacketParticipantsData, error) {
 	}
 	buf := bytes.NewReader(data)
 	err := binary.Read(buf, binary.LittleEndian, &pkt)
+	for i := range pkt.Participants {
+		if p := &pkt.Participants[i]; p.AIControlled == 0 && p.ShowOnlineNames == 0 {
+			p.Name = hiddenName(p.RaceNumber)
+		}
+	}
 	return pkt, err
 }
 
@@ -1060,6 +1169,11 @@ func decodeLobbyInfoPacket(data []byte) (PacketLobbyInfoData, error) {
 	}
 	buf := bytes.NewReader(data)
 	err := binary.Read(buf, binary.LittleEndian, &pkt)
+	for i := range pkt.LobbyPlayers {
+		if p := &pkt.LobbyPlayers[i]; p.AIControlled == 0 && p.ShowOnlineNames == 0 {
+			p.Name = hiddenName(p.CarNumber)
+		}
+	}
 	return pkt, err
 }
 
@@ -1135,8 +1249,13 @@ func decodeLapPositionsPacket(data []byte) (PacketLapPositionsData, error) {
 	return pkt, err
 }
 
-func broadcastMotionExFields(pkt PacketMotionExData) {
+func broadcastMotionExFields(pkt PacketMotionExData, frame *oscFrame) {
 	wheels := []string{"RL", "RR", "FL", "FR"}
+	// MotionEx only covers the player car
+	car := int(pkt.Header.PlayerCarIndex)
+	if car >= 22 {
+		car = -1
+	}
 	fields := []struct {
 		name   string
 		values [4]float32
@@ -1153,16 +1272,7 @@ func broadcastMotionExFields(pkt PacketMotionExData) {
 	for _, field := range fields {
 		for i, wheel := range wheels {
 			key := field.name + wheel
-			entry, ok := OSCAddresses[key]
-			if !ok {
-				continue
-			}
-			// Broadcast as plain text, not JSON
-			msg := fmt.Sprintf("MotionEx/%s %v", key, field.values[i])
-			broadcast([]byte(msg))
-			if Config.EnableOSC && entry.Enabled {
-				sendOSC(entry.Address, field.values[i])
-			}
+			publishWS(frame.header, "MotionEx/"+key, car, field.values[i])
 		}
 	}
 }
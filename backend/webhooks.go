@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Webhook posts live events (see publishEvent) to an HTTP endpoint. Events
+// is a list of event name patterns such as "lap_completed" or "session_*",
+// empty for defaultWebhookEvents. Template renders the JSON body from a
+// webhookPayload, the payload itself is sent without one. With a Secret
+// every request carries an HMAC-SHA256 signature of "<timestamp>.<body>".
+type Webhook struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	Events     []string          `json:"events,omitempty"`
+	Template   string            `json:"template,omitempty"`
+	Secret     string            `json:"secret,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	MaxRetries *int              `json:"maxRetries,omitempty"` // default 3
+	Enabled    bool              `json:"enabled"`
+}
+
+const (
+	defaultWebhookRetries  = 3
+	webhookQueueSize       = 256
+	webhookTimeout         = 10 * time.Second
+	webhookDeliveryLogSize = 200
+)
+
+// Retry backoff, variables so tests can shorten them
+var (
+	webhookFirstBackoff = time.Second
+	webhookMaxBackoff   = 30 * time.Second
+)
+
+// Events a webhook without an event list gets. High rate events (buttons,
+// overtakes, speed traps, alerts) have to be subscribed to explicitly.
+var defaultWebhookEvents = []string{"session_new", "lap_completed", "fastest_lap", "penalty", "final_classification"}
+
+// Request headers
+const (
+	webhookEventHeader     = "X-Telem-Bridge-Event"
+	webhookDeliveryHeader  = "X-Telem-Bridge-Delivery"
+	webhookTimestampHeader = "X-Telem-Bridge-Timestamp"
+	webhookSignatureHeader = "X-Telem-Bridge-Signature" // sha256=<hex>
+)
+
+// webhookPayload is the default body and the data templates are run with
+type webhookPayload struct {
+	Event       string    `json:"event"`
+	SessionUID  string    `json:"sessionUID"`
+	Frame       uint32    `json:"frame"`
+	SessionTime float32   `json:"sessionTime"`
+	Timestamp   time.Time `json:"timestamp"`
+	Details     any       `json:"details"`
+}
+
+// Template functions: {{json .Details}} embeds a value as JSON, {{driver 3}}
+// is the name of car 3
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"driver": func(car any) string {
+		n, ok := oscNumber(car)
+		if !ok {
+			return ""
+		}
+		return participantName(int(n))
+	},
+	"team": func(id any) string {
+		n, _ := oscNumber(id)
+		return TeamNames[int(n)]
+	},
+	"laptime": func(ms any) string {
+		n, _ := oscNumber(ms)
+		d := time.Duration(n) * time.Millisecond
+		return fmt.Sprintf("%d:%06.3f", int(d.Minutes()), (d % time.Minute).Seconds())
+	},
+}
+
+var Webhooks = []Webhook{}
+var webhooksConfigPath string
+
+// Serializes changes to Webhooks
+var webhooksMu sync.Mutex
+
+// webhookDelivery is one event for one webhook, and its entry in the
+// delivery log
+type webhookDelivery struct {
+	ID        string    `json:"id"`
+	Webhook   string    `json:"webhook"`
+	Event     string    `json:"event"`
+	Created   time.Time `json:"created"`
+	State     string    `json:"state"` // pending, delivered or failed
+	Attempts  int       `json:"attempts"`
+	Status    int       `json:"status,omitempty"` // HTTP status of the last attempt
+	Error     string    `json:"error,omitempty"`
+	LastTryMS int64     `json:"lastTryMS,omitempty"` // duration of the last attempt
+
+	payload webhookPayload
+}
+
+var webhookDeliveries struct {
+	sync.Mutex
+	log    []*webhookDelivery // oldest first
+	nextID atomic.Uint64
+}
+
+// webhookSender owns the queue of one webhook. Deliveries are posted in
+// order by a dedicated goroutine so a slow endpoint never stalls packet
+// handling; when the queue is full new events are dropped.
+type webhookSender struct {
+	hook     Webhook
+	template *template.Template
+	queue    chan *webhookDelivery
+}
+
+var webhookSendersMu sync.RWMutex
+var webhookSenders []*webhookSender
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+func InitWebhooksConfig() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	appDir := filepath.Join(configDir, "f1-telem-bridge")
+	os.MkdirAll(appDir, 0755)
+	webhooksConfigPath = filepath.Join(appDir, "webhooks.json")
+
+	if _, err := os.Stat(webhooksConfigPath); os.IsNotExist(err) {
+		SaveWebhooksConfig()
+	} else {
+		LoadWebhooksConfig()
+	}
+}
+
+func SaveWebhooksConfig() {
+	f, err := os.Create(webhooksConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not create webhooks config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(Webhooks); err != nil {
+		log.Printf("[error] Could not encode webhooks config: %v", err)
+	}
+}
+
+func LoadWebhooksConfig() {
+	f, err := os.Open(webhooksConfigPath)
+	if err != nil {
+		log.Printf("[error] Could not open webhooks config file: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&Webhooks); err != nil {
+		log.Printf("[error] Could not decode webhooks config: %v", err)
+	}
+}
+
+func findWebhook(name string) int {
+	for i, hook := range Webhooks {
+		if hook.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateWebhook(hook Webhook) error {
+	if hook.Name == "" || strings.Contains(hook.Name, "/") {
+		return fmt.Errorf("invalid webhook name %q", hook.Name)
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", hook.URL)
+	}
+	for _, pattern := range hook.Events {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid event pattern %q", pattern)
+		}
+	}
+	if hook.MaxRetries != nil && (*hook.MaxRetries < 0 || *hook.MaxRetries > 10) {
+		return fmt.Errorf("maxRetries must be 0-10")
+	}
+	_, err = parseWebhookTemplate(hook)
+	return err
+}
+
+func parseWebhookTemplate(hook Webhook) (*template.Template, error) {
+	if hook.Template == "" {
+		return nil, nil
+	}
+	t, err := template.New(hook.Name).Funcs(webhookTemplateFuncs).Option("missingkey=zero").Parse(hook.Template)
+	if err != nil {
+		return nil, fmt.Errorf("template: %v", err)
+	}
+	return t, nil
+}
+
+// restartWebhooks replaces the senders with one per enabled webhook. Queued
+// deliveries of the old senders are still sent.
+func restartWebhooks() {
+	webhookSendersMu.Lock()
+	defer webhookSendersMu.Unlock()
+	for _, s := range webhookSenders {
+		close(s.queue)
+	}
+	webhookSenders = nil
+	for _, hook := range Webhooks {
+		if !hook.Enabled {
+			continue
+		}
+		t, err := parseWebhookTemplate(hook)
+		if err != nil {
+			log.Printf("[error] Webhook %q: %v", hook.Name, err)
+			continue
+		}
+		s := &webhookSender{hook: hook, template: t, queue: make(chan *webhookDelivery, webhookQueueSize)}
+		go s.run()
+		webhookSenders = append(webhookSenders, s)
+	}
+	log.Printf("[service] Sending webhooks to %d endpoint(s)", len(webhookSenders))
+}
+
+func (s *webhookSender) wants(event string) bool {
+	patterns := s.hook.Events
+	if len(patterns) == 0 {
+		patterns = defaultWebhookEvents
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, event); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatchWebhooks queues an event for every webhook that subscribes to it,
+// it never blocks. Events from replays and the simulator are not posted.
+func dispatchWebhooks(header PacketHeader, name string, details any) {
+	if currentPacketSource != sourceLive {
+		return
+	}
+	webhookSendersMu.RLock()
+	defer webhookSendersMu.RUnlock()
+	if len(webhookSenders) == 0 {
+		return
+	}
+	payload := webhookPayload{
+		Event:       name,
+		SessionUID:  fmt.Sprint(header.SessionUID),
+		Frame:       header.FrameIdentifier,
+		SessionTime: header.SessionTime,
+		Timestamp:   time.Now().UTC(),
+		Details:     details,
+	}
+	for _, s := range webhookSenders {
+		if s.wants(name) {
+			s.enqueue(payload)
+		}
+	}
+}
+
+func (s *webhookSender) enqueue(payload webhookPayload) *webhookDelivery {
+	d := &webhookDelivery{
+		ID:      strconv.FormatUint(webhookDeliveries.nextID.Add(1), 10),
+		Webhook: s.hook.Name,
+		Event:   payload.Event,
+		Created: payload.Timestamp,
+		State:   "pending",
+		payload: payload,
+	}
+	select {
+	case s.queue <- d:
+	default:
+		d.State, d.Error = "failed", "queue full, dropped"
+	}
+	webhookDeliveries.Lock()
+	webhookDeliveries.log = append(webhookDeliveries.log, d)
+	if len(webhookDeliveries.log) > webhookDeliveryLogSize {
+		webhookDeliveries.log = append([]*webhookDelivery(nil), webhookDeliveries.log[len(webhookDeliveries.log)-webhookDeliveryLogSize:]...)
+	}
+	webhookDeliveries.Unlock()
+	return d
+}
+
+func (s *webhookSender) run() {
+	for d := range s.queue {
+		s.deliver(d)
+	}
+}
+
+// deliver posts one event, retrying network errors, 429 and 5xx responses
+// with exponential backoff
+func (s *webhookSender) deliver(d *webhookDelivery) {
+	body, err := s.render(d.payload)
+	if err != nil {
+		updateWebhookDelivery(d, func() { d.State, d.Error = "failed", err.Error() })
+		log.Printf("[error] Webhook %q event %s: %v", s.hook.Name, d.Event, err)
+		return
+	}
+	retries := defaultWebhookRetries
+	if s.hook.MaxRetries != nil {
+		retries = *s.hook.MaxRetries
+	}
+	backoff := webhookFirstBackoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		status, err := s.post(d, body)
+		retry := err != nil || status == http.StatusTooManyRequests || status >= 500
+		if err == nil && status >= 300 {
+			err = fmt.Errorf("HTTP %d", status)
+		}
+		updateWebhookDelivery(d, func() {
+			d.Attempts, d.Status, d.LastTryMS = attempt, status, time.Since(start).Milliseconds()
+			d.Error = ""
+			if err != nil {
+				d.Error = err.Error()
+			}
+			switch {
+			case err == nil:
+				d.State = "delivered"
+			case !retry || attempt > retries:
+				d.State = "failed"
+			}
+		})
+		if err == nil {
+			return
+		}
+		if !retry || attempt > retries {
+			log.Printf("[error] Webhook %q event %s failed after %d attempt(s): %v", s.hook.Name, d.Event, attempt, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+}
+
+func (s *webhookSender) render(payload webhookPayload) ([]byte, error) {
+	if s.template == nil {
+		return json.Marshal(payload)
+	}
+	var buf bytes.Buffer
+	if err := s.template.Execute(&buf, payload); err != nil {
+		return nil, fmt.Errorf("template: %v", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template output is not valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *webhookSender) post(d *webhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "f1-telem-bridge/"+Version)
+	for k, v := range s.hook.Headers {
+		req.Header.Set(k, v)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookDeliveryHeader, d.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	if s.hook.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(s.hook.Secret, timestamp, body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// signWebhook is the hex HMAC-SHA256 of "<timestamp>.<body>"
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func updateWebhookDelivery(d *webhookDelivery, update func()) {
+	webhookDeliveries.Lock()
+	defer webhookDeliveries.Unlock()
+	update()
+}
+
+// webhookView hides the secret in API responses
+type webhookView struct {
+	Webhook
+	Secret    string `json:"secret,omitempty"`
+	HasSecret bool   `json:"hasSecret"`
+}
+
+func newWebhookView(hook Webhook) webhookView {
+	return webhookView{Webhook: hook, HasSecret: hook.Secret != ""}
+}
+
+// REST API for webhooks:
+//
+//	GET/POST            /api/webhooks
+//	GET/PUT/DELETE      /api/webhooks/{name}
+//	POST                /api/webhooks/{name}/test   sends a "test" event
+//	GET                 /api/webhooks/deliveries    the delivery log, newest first
+func handleWebhooksAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] Webhooks API handler crashed: %v", r)
+		}
+	}()
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/webhooks"), "/"), "/")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case parts[0] == "":
+		handleWebhookList(w, r, body)
+	case len(parts) == 1 && parts[0] == "deliveries":
+		handleWebhookDeliveries(w, r)
+	case len(parts) == 1:
+		handleWebhook(w, r, parts[0], body)
+	case len(parts) == 2 && parts[1] == "test":
+		handleWebhookTest(w, r, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func handleWebhookList(w http.ResponseWriter, r *http.Request, body []byte) {
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		views := make([]webhookView, 0, len(Webhooks))
+		for _, hook := range Webhooks {
+			views = append(views, newWebhookView(hook))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(views)
+	case http.MethodPost:
+		var hook Webhook
+		err := json.Unmarshal(body, &hook)
+		if err == nil {
+			err = validateWebhook(hook)
+		}
+		if err == nil && hook.Name == "deliveries" {
+			err = fmt.Errorf("webhook name %q is reserved", hook.Name)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if findWebhook(hook.Name) >= 0 {
+			http.Error(w, "webhook already exists", http.StatusConflict)
+			return
+		}
+		Webhooks = append(Webhooks, hook)
+		SaveWebhooksConfig()
+		restartWebhooks()
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleWebhook(w http.ResponseWriter, r *http.Request, name string, body []byte) {
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	idx := findWebhook(name)
+	if idx < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newWebhookView(Webhooks[idx]))
+		return
+	case http.MethodPut:
+		// Fields left out keep their value, including the secret
+		hook := Webhooks[idx]
+		hook.Headers, hook.MaxRetries = nil, nil
+		err := json.Unmarshal(body, &hook)
+		if hook.Headers == nil {
+			hook.Headers = Webhooks[idx].Headers
+		}
+		if hook.MaxRetries == nil {
+			hook.MaxRetries = Webhooks[idx].MaxRetries
+		}
+		if err == nil {
+			err = validateWebhook(hook)
+		}
+		if err == nil && hook.Name != name && (findWebhook(hook.Name) >= 0 || hook.Name == "deliveries") {
+			err = fmt.Errorf("webhook %q already exists", hook.Name)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		Webhooks[idx] = hook
+	case http.MethodDelete:
+		Webhooks = append(Webhooks[:idx], Webhooks[idx+1:]...)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	SaveWebhooksConfig()
+	restartWebhooks()
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleWebhookTest(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	webhookSendersMu.RLock()
+	var sender *webhookSender
+	for _, s := range webhookSenders {
+		if s.hook.Name == name {
+			sender = s
+		}
+	}
+	var d *webhookDelivery
+	if sender != nil {
+		d = sender.enqueue(webhookPayload{Event: "test", Timestamp: time.Now().UTC(), Details: struct{}{}})
+	}
+	webhookSendersMu.RUnlock()
+	if d == nil {
+		http.Error(w, "no enabled webhook "+name, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"delivery": d.ID})
+}
+
+func handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hook := r.URL.Query().Get("webhook")
+	webhookDeliveries.Lock()
+	deliveries := []webhookDelivery{}
+	for i := len(webhookDeliveries.log) - 1; i >= 0; i-- {
+		if d := webhookDeliveries.log[i]; hook == "" || d.Webhook == hook {
+			deliveries = append(deliveries, *d)
+		}
+	}
+	webhookDeliveries.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a test endpoint answering with the given status codes
+// in turn, the last one repeats
+type webhookRecorder struct {
+	sync.Mutex
+	statuses []int
+	requests []recordedWebhookRequest
+}
+
+type recordedWebhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rec.Lock()
+	defer rec.Unlock()
+	rec.requests = append(rec.requests, recordedWebhookRequest{header: r.Header.Clone(), body: body})
+	status := http.StatusOK
+	if len(rec.statuses) > 0 {
+		status = rec.statuses[min(len(rec.requests), len(rec.statuses))-1]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestWebhookSender(t *testing.T, hook Webhook, statuses ...int) (*webhookSender, *webhookRecorder) {
+	t.Helper()
+	rec := &webhookRecorder{statuses: statuses}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	first, max := webhookFirstBackoff, webhookMaxBackoff
+	webhookFirstBackoff, webhookMaxBackoff = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { webhookFirstBackoff, webhookMaxBackoff = first, max })
+
+	if hook.Name == "" {
+		hook.Name = "test"
+	}
+	hook.URL = server.URL
+	hook.Enabled = true
+	if err := validateWebhook(hook); err != nil {
+		t.Fatalf("validateWebhook: %v", err)
+	}
+	tmpl, err := parseWebhookTemplate(hook)
+	if err != nil {
+		t.Fatalf("parseWebhookTemplate: %v", err)
+	}
+	return &webhookSender{hook: hook, template: tmpl, queue: make(chan *webhookDelivery, 1)}, rec
+}
+
+// deliverTestWebhook queues one event and delivers it synchronously
+func deliverTestWebhook(t *testing.T, s *webhookSender, event string, details any) webhookDelivery {
+	t.Helper()
+	s.enqueue(webhookPayload{Event: event, SessionUID: "42", Frame: 7, SessionTime: 1.5, Timestamp: time.Now().UTC(), Details: details})
+	d := <-s.queue
+	s.deliver(d)
+	webhookDeliveries.Lock()
+	defer webhookDeliveries.Unlock()
+	return *d
+}
+
+func TestWebhookDefaultBodyAndSignature(t *testing.T) {
+	s, rec := newTestWebhookSender(t, Webhook{Secret: "s3cret", Headers: map[string]string{"Authorization": "Bearer abc"}})
+	d := deliverTestWebhook(t, s, "penalty", PenaltyEvent{PenaltyType: 4, VehicleIdx: 2})
+
+	if d.State != "delivered" || d.Attempts != 1 || d.Status != http.StatusOK {
+		t.Fatalf("delivery = %+v, want delivered after 1 attempt", d)
+	}
+	if len(rec.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rec.requests))
+	}
+	req := rec.requests[0]
+
+	var payload struct {
+		Event       string
+		SessionUID  string
+		Frame       uint32
+		SessionTime float32
+		Details     PenaltyEvent
+	}
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("body is not JSON: %v\n%s", err, req.body)
+	}
+	if payload.Event != "penalty" || payload.SessionUID != "42" || payload.Frame != 7 || payload.SessionTime != 1.5 {
+		t.Errorf("payload = %+v", payload)
+	}
+	if payload.Details.PenaltyType != 4 || payload.Details.VehicleIdx != 2 {
+		t.Errorf("details = %+v", payload.Details)
+	}
+
+	if got := req.header.Get(webhookEventHeader); got != "penalty" {
+		t.Errorf("%s = %q", webhookEventHeader, got)
+	}
+	if got := req.header.Get(webhookDeliveryHeader); got != d.ID {
+		t.Errorf("%s = %q, want %q", webhookDeliveryHeader, got, d.ID)
+	}
+	if got := req.header.Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("Authorization = %q", got)
+	}
+	timestamp := req.header.Get(webhookTimestampHeader)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(req.body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get(webhookSignatureHeader) != want {
+		t.Errorf("%s = %q, want %q", webhookSignatureHeader, req.header.Get(webhookSignatureHeader), want)
+	}
+}
+
+func TestWebhookWithoutSecretIsNotSigned(t *testing.T) {
+	s, rec := newTestWebhookSender(t, Webhook{})
+	deliverTestWebhook(t, s, "session_new", nil)
+	if got := rec.requests[0].header.Get(webhookSignatureHeader); got != "" {
+		t.Errorf("%s = %q, want none", webhookSignatureHeader, got)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	s, rec := newTestWebhookSender(t, Webhook{
+		Template: `{"event": {{json .Event}}, "lap": {{.Details.LapNum}}, "time": {{json (laptime .Details.LapTimeMS)}}, "details": {{json .Details}}}`,
+	})
+	d := deliverTestWebhook(t, s, "lap_completed", LapCompletedEvent{VehicleIdx: 1, LapNum: 3, LapTimeMS: 89116})
+	if d.State != "delivered" {
+		t.Fatalf("delivery = %+v", d)
+	}
+	var body struct {
+		Event   string
+		Lap     int
+		Time    string
+		Details LapCompletedEvent
+	}
+	if err := json.Unmarshal(rec.requests[0].body, &body); err != nil {
+		t.Fatalf("body is not JSON: %v\n%s", err, rec.requests[0].body)
+	}
+	if body.Event != "lap_completed" || body.Lap != 3 || body.Time != "1:29.116" || body.Details.LapTimeMS != 89116 {
+		t.Errorf("body = %+v", body)
+	}
+}
+
+func TestWebhookTemplateInvalidJSON(t *testing.T) {
+	s, rec := newTestWebhookSender(t, Webhook{Template: `{"event": {{.Event}}}`})
+	d := deliverTestWebhook(t, s, "penalty", nil)
+	if d.State != "failed" || d.Attempts != 0 || d.Error == "" {
+		t.Errorf("delivery = %+v, want failed without attempts", d)
+	}
+	if len(rec.requests) != 0 {
+		t.Errorf("got %d requests, want none", len(rec.requests))
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	s, rec := newTestWebhookSender(t, Webhook{}, http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK)
+	d := deliverTestWebhook(t, s, "penalty", nil)
+	if d.State != "delivered" || d.Attempts != 3 || d.Status != http.StatusOK || d.Error != "" {
+		t.Errorf("delivery = %+v, want delivered after 3 attempts", d)
+	}
+	if len(rec.requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(rec.requests))
+	}
+	// Retries are the same delivery with the same body
+	for _, req := range rec.requests[1:] {
+		if req.header.Get(webhookDeliveryHeader) != d.ID || string(req.body) != string(rec.requests[0].body) {
+			t.Errorf("retry differs from the first attempt")
+		}
+	}
+}
+
+func TestWebhookRetriesExhausted(t *testing.T) {
+	retries := 2
+	s, rec := newTestWebhookSender(t, Webhook{MaxRetries: &retries}, http.StatusServiceUnavailable)
+	d := deliverTestWebhook(t, s, "penalty", nil)
+	if d.State != "failed" || d.Attempts != 3 || d.Status != http.StatusServiceUnavailable || d.Error == "" {
+		t.Errorf("delivery = %+v, want failed after 3 attempts", d)
+	}
+	if len(rec.requests) != 3 {
+		t.Errorf("got %d requests, want 3", len(rec.requests))
+	}
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	s, rec := newTestWebhookSender(t, Webhook{}, http.StatusBadRequest)
+	d := deliverTestWebhook(t, s, "penalty", nil)
+	if d.State != "failed" || d.Attempts != 1 || d.Status != http.StatusBadRequest {
+		t.Errorf("delivery = %+v, want failed after 1 attempt", d)
+	}
+	if len(rec.requests) != 1 {
+		t.Errorf("got %d requests, want 1", len(rec.requests))
+	}
+}
+
+func TestWebhookDeliveryLog(t *testing.T) {
+	s, _ := newTestWebhookSender(t, Webhook{Name: "log"}, http.StatusBadRequest)
+	s.enqueue(webhookPayload{Event: "penalty"})
+	d := <-s.queue
+
+	webhookDeliveries.Lock()
+	last := webhookDeliveries.log[len(webhookDeliveries.log)-1]
+	state := last.State
+	webhookDeliveries.Unlock()
+	if last != d || state != "pending" {
+		t.Fatalf("last log entry = %+v, want the pending delivery", last)
+	}
+
+	s.deliver(d)
+	webhookDeliveries.Lock()
+	state = last.State
+	webhookDeliveries.Unlock()
+	if state != "failed" {
+		t.Errorf("state = %q, want failed", state)
+	}
+
+	// A full queue drops the event, it is logged as failed
+	s.enqueue(webhookPayload{Event: "penalty"})
+	dropped := s.enqueue(webhookPayload{Event: "penalty"})
+	if dropped.State != "failed" || dropped.Attempts != 0 {
+		t.Errorf("dropped delivery = %+v", dropped)
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	tests := []struct {
+		events []string
+		event  string
+		want   bool
+	}{
+		{nil, "session_new", true},
+		{nil, "final_classification", true},
+		{nil, "button", false},
+		{nil, "alert", false},
+		{[]string{"*"}, "button", true},
+		{[]string{"session_*"}, "session_complete", true},
+		{[]string{"session_*"}, "lap_completed", false},
+		{[]string{"penalty", "alert"}, "alert", true},
+	}
+	for _, tt := range tests {
+		s := &webhookSender{hook: Webhook{Events: tt.events}}
+		if got := s.wants(tt.event); got != tt.want {
+			t.Errorf("events %v wants(%q) = %v, want %v", tt.events, tt.event, got, tt.want)
+		}
+	}
+}
+
+// Replayed and simulated events still reach WebSocket and OSC, but are not
+// posted to webhooks
+func TestWebhookOnlyLiveEvents(t *testing.T) {
+	initTestBridge(t)
+	s, _ := newTestWebhookSender(t, Webhook{Events: []string{"penalty"}})
+	s.queue = make(chan *webhookDelivery, 8)
+	webhookSendersMu.Lock()
+	senders := webhookSenders
+	webhookSenders = []*webhookSender{s}
+	webhookSendersMu.Unlock()
+	t.Cleanup(func() {
+		webhookSendersMu.Lock()
+		webhookSenders = senders
+		webhookSendersMu.Unlock()
+	})
+
+	sim := newSimulator(simConfig{Seed: 1, Cars: 2, Laps: 1, Rate: 10})
+	simulated := sim.event("PENA", PenaltyEvent{PenaltyType: 4, VehicleIdx: 1})
+	live := append([]byte(nil), simulated...)
+	live[3] = 1 // GameMajorVersion of the real game
+
+	tests := []struct {
+		name   string
+		data   []byte
+		source packetSource
+		posted bool
+	}{
+		{"live", live, sourceLive, true},
+		{"replay", live, sourceReplay, false},
+		{"simulator on the UDP port", simulated, sourceLive, false},
+		{"simulated replay", simulated, sourceReplay, false},
+	}
+	for _, tt := range tests {
+		handleUDPPacket(tt.data, tt.source)
+		if posted := len(s.queue) > 0; posted != tt.posted {
+			t.Errorf("%s: posted = %v, want %v", tt.name, posted, tt.posted)
+		}
+		for len(s.queue) > 0 {
+			<-s.queue
+		}
+	}
+
+	var events map[string]eventState
+	getTestAPI(t, handleStateAPI, "/api/state/event", &events)
+	if _, ok := events["penalty"]; !ok {
+		t.Error("replayed penalty is missing from /api/state/event")
+	}
+	if currentPacketSource != sourceLive {
+		t.Errorf("currentPacketSource = %v after handling, want sourceLive", currentPacketSource)
+	}
+}
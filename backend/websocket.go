@@ -1,15 +1,200 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var clients = make(map[*websocket.Conn]bool)
-var clientsMutex sync.Mutex
+// WebSocket protocol, version 1
+//
+// Every data message is a JSON wsMessage, e.g.
+//
+//	{"v":1,"topic":"LapData/3/CarPosition","car":3,"frame":1234,"time":81.5,"type":"int","value":7}
+//
+// Enum ids also carry their display name, e.g.
+//
+//	{"v":1,"topic":"Session/TrackId","frame":1234,"time":81.5,"type":"int","value":7,"label":"Silverstone"}
+//
+// Topics are the "/" separated field paths of the decoded packets. Clients
+// pick topics by sending
+//
+//	{"op":"subscribe","topics":["LapData/*/CarPosition","Event/**"]}
+//	{"op":"unsubscribe","topics":["Event/**"]}
+//
+// and get {"v":1,"op":"subscriptions","topics":[...]} back. In patterns "*"
+// matches one topic segment and "**" any number of trailing segments. A
+// client receives every topic until its first subscribe.
+const wsProtocolVersion = 1
+
+type wsMessage struct {
+	V     int         `json:"v"`
+	Topic string      `json:"topic"`
+	Car   *int        `json:"car,omitempty"` // set for per-car topics
+	Frame uint32      `json:"frame"`
+	Time  float32     `json:"time"` // session time
+	Type  string      `json:"type"` // int, float, bool, string, object or array
+	Value interface{} `json:"value"`
+	Label string      `json:"label,omitempty"` // display name of enum ids, e.g. "Silverstone"
+}
+
+type wsRequest struct {
+	Op     string   `json:"op"`
+	Topics []string `json:"topics"`
+}
+
+type wsReply struct {
+	V      int      `json:"v"`
+	Op     string   `json:"op"`
+	Topics []string `json:"topics,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Slow client policies, what happens when a client's send queue is full
+const (
+	WSSlowClientDrop       = "drop"       // drop the message
+	WSSlowClientDisconnect = "disconnect" // close the connection
+)
+
+// The first packets of a session produce thousands of topics at once
+const defaultWSQueueSize = 16384
+
+const (
+	wsWriteWait  = 5 * time.Second  // max time for a single write
+	wsPongWait   = 30 * time.Second // client must answer a ping within this
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// wsClient is one WebSocket connection. Messages are queued on send and
+// written by the client's own writer goroutine, so a slow client never
+// blocks the UDP decode loop.
+type wsClient struct {
+	id        uint64
+	conn      *websocket.Conn
+	remote    string
+	connected time.Time
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Guarded by clientsMutex
+	subscribed bool // false until the first subscribe, everything is sent
+	patterns   map[string][]string
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newWSClient(conn *websocket.Conn, r *http.Request) *wsClient {
+	queueSize := Config.WSQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultWSQueueSize
+	}
+	return &wsClient{
+		id:        wsClientID.Add(1),
+		conn:      conn,
+		remote:    r.RemoteAddr,
+		connected: time.Now(),
+		send:      make(chan []byte, queueSize),
+		done:      make(chan struct{}),
+		patterns:  map[string][]string{},
+	}
+}
+
+// enqueue queues a message without blocking and applies the slow client
+// policy when the queue is full
+func (c *wsClient) enqueue(message []byte) {
+	select {
+	case <-c.done:
+		return
+	case c.send <- message:
+	default:
+		c.dropped.Add(1)
+		if Config.WSSlowClientPolicy == WSSlowClientDisconnect {
+			c.closeOnce.Do(func() {
+				log.Printf("[warn] WebSocket client %d (%s) too slow, disconnecting", c.id, c.remote)
+				close(c.done)
+			})
+		}
+	}
+}
+
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+func (c *wsClient) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+			c.sent.Add(1)
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
+// wants reports whether a topic, split into segments, matches a subscription
+func (c *wsClient) wants(topic []string) bool {
+	if !c.subscribed {
+		return true
+	}
+	for _, pattern := range c.patterns {
+		if topicMatches(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *wsClient) topics() []string {
+	topics := []string{}
+	for pattern := range c.patterns {
+		topics = append(topics, pattern)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func topicMatches(pattern, topic []string) bool {
+	for i, segment := range pattern {
+		if segment == "**" {
+			return true
+		}
+		if i >= len(topic) || (segment != "*" && segment != topic[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
+
+var clients = make(map[*wsClient]struct{})
+var clientsMutex sync.RWMutex
+var wsClientID atomic.Uint64
 
 var upgrader = websocket.Upgrader{}
 
@@ -26,31 +211,149 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	client := newWSClient(conn, r)
 	clientsMutex.Lock()
-	clients[conn] = true
+	clients[client] = struct{}{}
 	clientsMutex.Unlock()
+	go client.writeLoop()
 
+	conn.SetReadLimit(64 * 1024)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
 	for {
-		_, _, err := conn.ReadMessage()
+		_, data, err := conn.ReadMessage()
 		if err != nil {
-			clientsMutex.Lock()
-			delete(clients, conn)
-			clientsMutex.Unlock()
-			conn.Close()
 			break
 		}
+		handleWSRequest(client, data)
 	}
+	clientsMutex.Lock()
+	delete(clients, client)
+	clientsMutex.Unlock()
+	client.close()
 }
 
-// Broadcast to all connected clients
-func broadcast(message []byte) {
+func handleWSRequest(client *wsClient, data []byte) {
+	var req wsRequest
+	reply := wsReply{V: wsProtocolVersion, Op: "subscriptions"}
 	clientsMutex.Lock()
-	defer clientsMutex.Unlock()
-	for conn := range clients {
-		err := conn.WriteMessage(websocket.TextMessage, message)
-		if err != nil {
-			conn.Close()
-			delete(clients, conn)
+	if err := json.Unmarshal(data, &req); err != nil {
+		reply.Op, reply.Error = "error", err.Error()
+	} else {
+		switch req.Op {
+		case "subscribe":
+			client.subscribed = true
+			for _, topic := range req.Topics {
+				client.patterns[topic] = strings.Split(topic, "/")
+			}
+		case "unsubscribe":
+			for _, topic := range req.Topics {
+				delete(client.patterns, topic)
+			}
+		default:
+			reply.Op, reply.Error = "error", "unknown op "+req.Op
+		}
+		reply.Topics = client.topics()
+	}
+	clientsMutex.Unlock()
+	msg, _ := json.Marshal(reply)
+	client.enqueue(msg)
+}
+
+// publishWS sends one value to the clients subscribed to its topic. car is
+// -1 for topics that don't belong to a single car.
+func publishWS(header PacketHeader, topic string, car int, value interface{}) {
+	msg := wsMessage{
+		V:     wsProtocolVersion,
+		Topic: topic,
+		Frame: header.FrameIdentifier,
+		Time:  header.SessionTime,
+		Type:  wsValueType(value),
+		Value: value,
+		Label: enumLabel(topic[strings.LastIndexByte(topic, '/')+1:], value),
+	}
+	if car >= 0 {
+		msg.Car = &car
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		if Config.DebugOutput {
+			log.Printf("[debug] WebSocket topic %s not sent: %v", topic, err)
 		}
+		return
+	}
+	broadcast(strings.Split(topic, "/"), data)
+}
+
+func wsValueType(value interface{}) string {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Bool:
+		return "bool"
+	case reflect.String:
+		return "string"
+	case reflect.Array, reflect.Slice:
+		return "array"
+	}
+	return "object"
+}
+
+// Broadcast to all connected clients subscribed to the topic
+func broadcast(topic []string, message []byte) {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	for client := range clients {
+		if client.wants(topic) {
+			client.enqueue(message)
+		}
+	}
+}
+
+type wsClientStats struct {
+	ID            uint64    `json:"id"`
+	Remote        string    `json:"remote"`
+	Connected     time.Time `json:"connected"`
+	Subscriptions []string  `json:"subscriptions"`
+	Queued        int       `json:"queued"`
+	Capacity      int       `json:"capacity"`
+	Sent          uint64    `json:"sent"`
+	Dropped       uint64    `json:"dropped"`
+}
+
+// REST API for connected WebSocket clients and their queue counters
+func handleWSClientsAPI(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	if os.Getenv("DEV") == "1" && r.Method == http.MethodOptions {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] WSClients API handler crashed: %v", r)
+		}
+	}()
+
+	clientsMutex.RLock()
+	stats := make([]wsClientStats, 0, len(clients))
+	for c := range clients {
+		stats = append(stats, wsClientStats{
+			ID:            c.id,
+			Remote:        c.remote,
+			Connected:     c.connected,
+			Subscriptions: c.topics(),
+			Queued:        len(c.send),
+			Capacity:      cap(c.send),
+			Sent:          c.sent.Load(),
+			Dropped:       c.dropped.Load(),
+		})
 	}
+	clientsMutex.RUnlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
 }
@@ -10,16 +10,40 @@ export type TelemetryData = {
 	RPM: number;
 };
 
-export function useTelemetry() {
-	const [data, setData] = useState<TelemetryData | null>(null);
+// Envelope of every data message on /ws (protocol version 1)
+export type TelemetryMessage<T = unknown> = {
+	v: number;
+	topic: string;
+	car?: number;
+	frame: number;
+	time: number;
+	type: "int" | "float" | "bool" | "string" | "object" | "array";
+	value: T;
+	label?: string; // display name of enum ids, e.g. "Silverstone"
+};
+
+const SUMMARY_TOPIC = "CarTelemetry/summary";
+
+// useTelemetryTopics subscribes to topic patterns ("*" matches one segment,
+// "**" the rest) and calls onMessage for every matching message
+export function useTelemetryTopics(topics: string[], onMessage: (msg: TelemetryMessage) => void) {
+	const key = topics.join("\n");
 
 	useEffect(() => {
 		const ws = new WebSocket("ws://localhost:1337/ws");
 
+		ws.onopen = () => {
+			ws.send(JSON.stringify({ op: "subscribe", topics }));
+		};
+
 		ws.onmessage = (event) => {
 			try {
-				const parsed: TelemetryData = JSON.parse(event.data);
-				setData(parsed);
+				const parsed = JSON.parse(event.data);
+				if (parsed.op === "error") {
+					console.error("WebSocket error", parsed.error);
+				} else if (parsed.topic) {
+					onMessage(parsed as TelemetryMessage);
+				}
 			} catch (err) {
 				console.error("WebSocket JSON error", err);
 			}
@@ -32,7 +56,18 @@ export function useTelemetry() {
 		return () => {
 			ws.close();
 		};
-	}, []);
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, [key]);
+}
+
+export function useTelemetry() {
+	const [data, setData] = useState<TelemetryData | null>(null);
+
+	useTelemetryTopics([SUMMARY_TOPIC], (msg) => {
+		if (msg.topic === SUMMARY_TOPIC) {
+			setData(msg.value as TelemetryData);
+		}
+	});
 
 	return data;
 }
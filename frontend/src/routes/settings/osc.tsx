@@ -29,7 +29,15 @@ export const Route = createFileRoute('/settings/osc')({
     component: OSCSettingsPage,
 });
 
+interface OSCDestination {
+    name: string;
+    addr: string;
+    port: number;
+}
+
 function OSCSettingsPage() {
+    const [destinations, setDestinations] = useState<OSCDestination[] | null>(null);
+    const [destination, setDestination] = useState<string | null>(null);
     const [oscAddresses, setOscAddresses] = useState<Record<string, OSCAddressEntry> | null>(null);
     const [saveStatus, setSaveStatus] = useState<null | 'saving' | 'saved'>(null);
     const [copiedKey, setCopiedKey] = useState<string | null>(null);
@@ -38,11 +46,23 @@ function OSCSettingsPage() {
     const toastRef = useRef<HTMLDivElement | null>(null);
 
     useEffect(() => {
-        fetch('/api/osc-addresses')
+        fetch('/api/osc-destinations')
             .then(r => r.json())
-            .then(setOscAddresses);
+            .then((list: OSCDestination[]) => {
+                setDestinations(list);
+                const preferred = list.find(d => d.name === 'default') ?? list[0];
+                setDestination(preferred ? preferred.name : null);
+            });
     }, []);
 
+    useEffect(() => {
+        if (!destination) return;
+        setOscAddresses(null);
+        fetch(`/api/osc-destinations/${encodeURIComponent(destination)}/addresses`)
+            .then(r => r.json())
+            .then(setOscAddresses);
+    }, [destination]);
+
     useEffect(() => {
         if (saveStatus && toastRef.current) {
             // Show toast when saveStatus changes
@@ -60,8 +80,9 @@ function OSCSettingsPage() {
     }, [saveStatus]);
 
     function saveAddresses(addresses: Record<string, OSCAddressEntry>) {
+        if (!destination) return;
         setSaveStatus('saving');
-        fetch('/api/osc-addresses', {
+        fetch(`/api/osc-destinations/${encodeURIComponent(destination)}/addresses`, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify(addresses),
@@ -131,7 +152,23 @@ function OSCSettingsPage() {
                 </div>
             )}
             <h1 className="h3 mb-4">OSC Address Settings</h1>
-            {!oscAddresses ? (
+            <div className="mb-3">
+                <label className="form-label fw-bold" htmlFor="osc-destination">Destination</label>
+                <select
+                    className="form-select w-auto"
+                    id="osc-destination"
+                    value={destination ?? ''}
+                    onChange={e => setDestination(e.target.value)}
+                    disabled={!destinations || destinations.length === 0}
+                >
+                    {destinations?.map(d => (
+                        <option key={d.name} value={d.name}>{d.name} ({d.addr}:{d.port})</option>
+                    ))}
+                </select>
+            </div>
+            {destinations && destinations.length === 0 ? (
+                <div className="alert alert-warning">No OSC destinations configured.</div>
+            ) : !oscAddresses ? (
                 <div className="alert alert-info">Loading...</div>
             ) : (
                 <div className="mb-4">